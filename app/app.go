@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/ipoluianov/gomisc/logger"
 	"github.com/ipoluianov/xchgr/xchgr_server"
@@ -18,6 +19,12 @@ var ServiceDescription string
 var ServiceRunFunc func() error
 var ServiceStopFunc func()
 
+// ConfigFile is the path to the config file. Empty means xchgr.json near the executable if it exists.
+var ConfigFile string
+
+// startDir is the working directory of the caller, init changes it to the executable folder
+var startDir string
+
 func SetAppPath() {
 	exePath, _ := osext.ExecutableFolder()
 	err := os.Chdir(exePath)
@@ -27,6 +34,7 @@ func SetAppPath() {
 }
 
 func init() {
+	startDir, _ = os.Getwd()
 	SetAppPath()
 }
 
@@ -36,9 +44,28 @@ func TryService() bool {
 	uninstallFlagPtr := flag.Bool("uninstall", false, "Uninstall service")
 	startFlagPtr := flag.Bool("start", false, "Start service")
 	stopFlagPtr := flag.Bool("stop", false, "Stop service")
+	configFlagPtr := flag.String("config", "", "Config file")
+	printDefaultConfigFlagPtr := flag.Bool("print-default-config", false, "Print the default config")
+	checkConfigFlagPtr := flag.Bool("check-config", false, "Check the config file")
 
 	flag.Parse()
 
+	ConfigFile = *configFlagPtr
+	if ConfigFile != "" && !filepath.IsAbs(ConfigFile) {
+		// Relative to the directory the command is run from
+		ConfigFile = filepath.Join(startDir, ConfigFile)
+	}
+
+	if *printDefaultConfigFlagPtr {
+		fmt.Println(xchgr_server.DefaultConfig().String())
+		return true
+	}
+
+	if *checkConfigFlagPtr {
+		CheckConfig()
+		return true
+	}
+
 	if *serviceFlagPtr {
 		runService()
 		return true
@@ -74,6 +101,10 @@ func NewSvcConfig() *service.Config {
 		Description: ServiceDescription,
 	}
 	SvcConfig.Arguments = append(SvcConfig.Arguments, "-service")
+	if ConfigFile != "" {
+		// ConfigFile is absolute, the service runs in another directory
+		SvcConfig.Arguments = append(SvcConfig.Arguments, "-config", ConfigFile)
+	}
 	return SvcConfig
 }
 
@@ -162,23 +193,57 @@ func (p *program) Stop(_ service.Service) error {
 // ///////////////////////////
 var system *xchgr_server.System
 
-var systems []*xchgr_server.System
+// LoadConfig reads ConfigFile or returns the default config if no file is configured
+func LoadConfig() (xchgr_server.Config, error) {
+	configFile := ConfigFile
+	if configFile == "" {
+		exePath, _ := osext.ExecutableFolder()
+		configFile = filepath.Join(exePath, "xchgr.json")
+		if _, err := os.Stat(configFile); os.IsNotExist(err) {
+			logger.Println("[i]", "App::LoadConfig", "no config file, using defaults")
+			return xchgr_server.DefaultConfig(), nil
+		}
+	}
+	logger.Println("[i]", "App::LoadConfig", configFile)
+	return xchgr_server.LoadConfig(configFile)
+}
+
+func CheckConfig() {
+	config, err := LoadConfig()
+	if err == nil {
+		err = config.Check()
+	}
+	if err == nil {
+		err = config.CheckFiles()
+	}
+	if err != nil {
+		fmt.Println("Config error:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Config OK")
+}
 
 func Start() error {
 	logger.Println("[i]", "App::Start", "begin")
 	TuneFDs()
 
-	real := true
-	if real {
-		system = xchgr_server.NewSystem(8084)
-		system.Start()
-	} else {
-		systems = make([]*xchgr_server.System, 0)
-		for i := 8084; i < 8087; i++ {
-			system = xchgr_server.NewSystem(i)
-			system.Start()
-			systems = append(systems, system)
-		}
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	err = config.Check()
+	if err != nil {
+		return err
+	}
+
+	system, err = xchgr_server.NewSystem(config)
+	if err != nil {
+		return err
+	}
+	err = system.Start()
+	if err != nil {
+		return err
 	}
 
 	logger.Println("[i]", "App::Start", "end")
@@ -0,0 +1,164 @@
+package name_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipoluianov/gomisc/logger"
+)
+
+//////////////////////////////////////////////////////
+// Zone file (JSON)
+// {
+//   "ttl": 300,
+//   "records": [
+//     {"name": "work.xchg", "address": "#pem53...", "ttl": 60}
+//   ]
+// }
+// "ttl" of the zone is used for records without ttl.
+// Names are case-insensitive, one address can have many names.
+//////////////////////////////////////////////////////
+
+const (
+	DEFAULT_ZONE_TTL = 300
+)
+
+type zoneFile struct {
+	TTL     int      `json:"ttl"`
+	Records []Record `json:"records"`
+}
+
+type zone struct {
+	names     map[string]Record
+	addresses map[string][]Record
+}
+
+// FileZone resolves names from the zone file and reloads it when it changes
+type FileZone struct {
+	mtx         sync.Mutex
+	fileName    string
+	period      time.Duration
+	zone        zone
+	modTime     time.Time
+	lastCheckDT time.Time
+	lastLoadErr error
+}
+
+// DefaultRecords are used while there is no zone file
+func DefaultRecords() []Record {
+	return []Record{
+		{Name: "work.xchg", Address: "#pem53ka2436w5bqgeaaqjud5uki4i7msbphqdezjehkz6ghp", TTL: DEFAULT_ZONE_TTL},
+	}
+}
+
+func NewFileZone(fileName string, period time.Duration) *FileZone {
+	var c FileZone
+	c.fileName = fileName
+	c.period = period
+	c.zone = buildZone(DefaultRecords(), DEFAULT_ZONE_TTL)
+	c.check(time.Now())
+	return &c
+}
+
+func buildZone(records []Record, defaultTTL int) zone {
+	var z zone
+	z.names = make(map[string]Record)
+	z.addresses = make(map[string][]Record)
+	for _, r := range records {
+		r.Name = NormalizeName(r.Name)
+		r.Address = NormalizeAddress(r.Address)
+		if r.TTL <= 0 {
+			r.TTL = defaultTTL
+		}
+		z.names[r.Name] = r
+	}
+	for _, r := range z.names {
+		z.addresses[r.Address] = append(z.addresses[r.Address], r)
+	}
+	for _, records := range z.addresses {
+		sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	}
+	return z
+}
+
+func loadZoneFile(fileName string) (zone, error) {
+	bs, err := os.ReadFile(fileName)
+	if err != nil {
+		return zone{}, err
+	}
+	var f zoneFile
+	err = json.Unmarshal(bs, &f)
+	if err != nil {
+		return zone{}, fmt.Errorf("parse %s: %v", fileName, err)
+	}
+	if f.TTL <= 0 {
+		f.TTL = DEFAULT_ZONE_TTL
+	}
+	for i, r := range f.Records {
+		if NormalizeName(r.Name) == "" || r.Address == "" {
+			return zone{}, fmt.Errorf("%s: record %d: name and address are required", fileName, i)
+		}
+	}
+	return buildZone(f.Records, f.TTL), nil
+}
+
+// check reloads the zone file if it has changed. On errors the previous zone is kept.
+func (c *FileZone) check(now time.Time) {
+	if now.Sub(c.lastCheckDT) < c.period && !c.lastCheckDT.IsZero() {
+		return
+	}
+	c.lastCheckDT = now
+
+	st, err := os.Stat(c.fileName)
+	if err != nil {
+		if !os.IsNotExist(err) && (c.lastLoadErr == nil || c.lastLoadErr.Error() != err.Error()) {
+			logger.Println("FileZone error:", err)
+		}
+		c.lastLoadErr = err
+		return
+	}
+	if st.ModTime().Equal(c.modTime) {
+		return
+	}
+
+	z, err := loadZoneFile(c.fileName)
+	if err != nil {
+		logger.Println("FileZone load error:", err)
+		c.lastLoadErr = err
+		return
+	}
+	c.zone = z
+	c.modTime = st.ModTime()
+	c.lastLoadErr = nil
+	logger.Println("FileZone loaded", c.fileName, "names:", len(z.names))
+}
+
+func (c *FileZone) Resolve(name string) (Record, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.check(time.Now())
+
+	r, ok := c.zone.names[NormalizeName(name)]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (c *FileZone) ReverseLookup(address string) ([]Record, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.check(time.Now())
+
+	records := c.zone.addresses[NormalizeAddress(address)]
+	if len(records) == 0 {
+		return nil, ErrNotFound
+	}
+	result := make([]Record, len(records))
+	copy(result, records)
+	return result, nil
+}
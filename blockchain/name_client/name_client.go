@@ -2,17 +2,66 @@ package name_client
 
 import "errors"
 
+// NameClient resolves names with the resolvers in order: the first resolver that knows the name wins
 type NameClient struct {
+	resolvers []Resolver
 }
 
-func NewNameClient() *NameClient {
+func NewNameClient(resolvers ...Resolver) *NameClient {
 	var c NameClient
+	c.resolvers = resolvers
 	return &c
 }
 
 func (c *NameClient) GetAddressByName(name string) (string, error) {
-	if name == "work.xchg" {
-		return "#pem53ka2436w5bqgeaaqjud5uki4i7msbphqdezjehkz6ghp", nil
+	r, err := c.Resolve(name)
+	if err != nil {
+		return "", err
 	}
-	return "", errors.New("no address")
+	return r.Address, nil
+}
+
+func (c *NameClient) Resolve(name string) (Record, error) {
+	for _, resolver := range c.resolvers {
+		r, err := resolver.Resolve(name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return r, err
+	}
+	return Record{}, ErrNotFound
+}
+
+// ReverseLookup returns the names of the address from all resolvers.
+// Names shadowed by a previous resolver are skipped.
+func (c *NameClient) ReverseLookup(address string) ([]Record, error) {
+	result := make([]Record, 0)
+	for i, resolver := range c.resolvers {
+		records, err := resolver.ReverseLookup(address)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if i > 0 && c.shadowed(r.Name, i) {
+				continue
+			}
+			result = append(result, r)
+		}
+	}
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+	return result, nil
+}
+
+func (c *NameClient) shadowed(name string, index int) bool {
+	for _, resolver := range c.resolvers[:index] {
+		if _, err := resolver.Resolve(name); err == nil {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,258 @@
+package name_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNameTaken    = errors.New("name is taken")
+	ErrNotOwner     = errors.New("address is not the owner of the name")
+	ErrInvalidName  = errors.New("invalid name")
+	ErrTooManyNames = errors.New("too many names of the address")
+)
+
+type Registration struct {
+	Name    string    `json:"name"`
+	Address string    `json:"address"`
+	Expires time.Time `json:"expires"`
+}
+
+// Registry keeps the names claimed by the owners of addresses.
+// It is saved to the file after every change.
+type Registry struct {
+	mtx           sync.Mutex
+	fileName      string
+	ttl           int
+	maxPerAddress int
+	registrations map[string]Registration
+}
+
+func NewRegistry(fileName string, ttl int, maxPerAddress int) (*Registry, error) {
+	var c Registry
+	c.fileName = fileName
+	c.ttl = ttl
+	c.maxPerAddress = maxPerAddress
+	c.registrations = make(map[string]Registration)
+
+	bs, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var registrations []Registration
+	err = json.Unmarshal(bs, &registrations)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", fileName, err)
+	}
+	for _, r := range registrations {
+		c.registrations[r.Name] = r
+	}
+	return &c, nil
+}
+
+// ValidateName checks that the name is dot-separated labels of [a-z0-9-]
+func ValidateName(name string) error {
+	if len(name) == 0 || len(name) > 253 {
+		return ErrInvalidName
+	}
+	labelLen := 0
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case ch == '.':
+			if labelLen == 0 {
+				return ErrInvalidName
+			}
+			labelLen = 0
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9', ch == '-':
+			labelLen++
+			if labelLen > 63 {
+				return ErrInvalidName
+			}
+		default:
+			return ErrInvalidName
+		}
+	}
+	if labelLen == 0 {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// get returns the registration if it has not expired
+func (c *Registry) get(name string, now time.Time) (Registration, bool) {
+	r, ok := c.registrations[name]
+	if !ok || now.After(r.Expires) {
+		return Registration{}, false
+	}
+	return r, true
+}
+
+// Register claims a free or expired name for the address
+func (c *Registry) Register(name string, address string, expires time.Time, now time.Time) (Registration, error) {
+	name = NormalizeName(name)
+	address = NormalizeAddress(address)
+	if err := ValidateName(name); err != nil {
+		return Registration{}, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	r, ok := c.get(name, now)
+	if ok && r.Address != address {
+		return Registration{}, ErrNameTaken
+	}
+	if !ok && c.namesOf(address, now) >= c.maxPerAddress {
+		return Registration{}, ErrTooManyNames
+	}
+	r = Registration{Name: name, Address: address, Expires: expires}
+	return r, c.set(r)
+}
+
+// Renew sets the new expiry of the name of the owner
+func (c *Registry) Renew(name string, owner string, expires time.Time, now time.Time) (Registration, error) {
+	return c.update(name, owner, now, func(r *Registration) {
+		r.Expires = expires
+	})
+}
+
+// Transfer moves the name of the owner to another address
+func (c *Registry) Transfer(name string, owner string, to string, now time.Time) (Registration, error) {
+	to = NormalizeAddress(to)
+	c.mtx.Lock()
+	tooMany := to != NormalizeAddress(owner) && c.namesOf(to, now) >= c.maxPerAddress
+	c.mtx.Unlock()
+	if tooMany {
+		return Registration{}, ErrTooManyNames
+	}
+	return c.update(name, owner, now, func(r *Registration) {
+		r.Address = to
+	})
+}
+
+// Release frees the name of the owner
+func (c *Registry) Release(name string, owner string, now time.Time) error {
+	name = NormalizeName(name)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	r, ok := c.get(name, now)
+	if !ok {
+		return ErrNotFound
+	}
+	if r.Address != NormalizeAddress(owner) {
+		return ErrNotOwner
+	}
+	delete(c.registrations, name)
+	return c.save()
+}
+
+// namesOf counts the names of the address that have not expired. c.mtx must be held.
+func (c *Registry) namesOf(address string, now time.Time) int {
+	count := 0
+	for _, r := range c.registrations {
+		if r.Address == address && !now.After(r.Expires) {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *Registry) update(name string, owner string, now time.Time, f func(r *Registration)) (Registration, error) {
+	name = NormalizeName(name)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	r, ok := c.get(name, now)
+	if !ok {
+		return Registration{}, ErrNotFound
+	}
+	if r.Address != NormalizeAddress(owner) {
+		return Registration{}, ErrNotOwner
+	}
+	f(&r)
+	return r, c.set(r)
+}
+
+func (c *Registry) set(r Registration) error {
+	prev, existed := c.registrations[r.Name]
+	c.registrations[r.Name] = r
+	err := c.save()
+	if err != nil {
+		if existed {
+			c.registrations[r.Name] = prev
+		} else {
+			delete(c.registrations, r.Name)
+		}
+	}
+	return err
+}
+
+// save writes the registrations to a temporary file and renames it
+func (c *Registry) save() error {
+	now := time.Now()
+	registrations := make([]Registration, 0, len(c.registrations))
+	for name, r := range c.registrations {
+		if now.After(r.Expires) {
+			delete(c.registrations, name)
+			continue
+		}
+		registrations = append(registrations, r)
+	}
+	sort.Slice(registrations, func(i, j int) bool { return registrations[i].Name < registrations[j].Name })
+
+	bs, err := json.MarshalIndent(registrations, "", " ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.fileName), 0755)
+	if err != nil {
+		return err
+	}
+	tmpFileName := c.fileName + ".tmp"
+	err = os.WriteFile(tmpFileName, bs, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, c.fileName)
+}
+
+func (c *Registry) record(r Registration) Record {
+	return Record{Name: r.Name, Address: r.Address, TTL: c.ttl}
+}
+
+func (c *Registry) Resolve(name string) (Record, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	r, ok := c.get(NormalizeName(name), time.Now())
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return c.record(r), nil
+}
+
+func (c *Registry) ReverseLookup(address string) ([]Record, error) {
+	address = NormalizeAddress(address)
+	now := time.Now()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	result := make([]Record, 0)
+	for _, r := range c.registrations {
+		if r.Address == address && !now.After(r.Expires) {
+			result = append(result, c.record(r))
+		}
+	}
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
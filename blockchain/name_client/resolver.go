@@ -0,0 +1,36 @@
+package name_client
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrNotFound = errors.New("name not found")
+
+type Record struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Seconds the record may be cached
+	TTL int `json:"ttl"`
+}
+
+type Resolver interface {
+	// Resolve returns the record of the name or ErrNotFound
+	Resolve(name string) (Record, error)
+	// ReverseLookup returns the records of all names of the address or ErrNotFound
+	ReverseLookup(address string) ([]Record, error)
+}
+
+// NormalizeName returns the name in lower case without the trailing dot
+func NormalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// NormalizeAddress returns the address in lower case with the "#" prefix
+func NormalizeAddress(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if !strings.HasPrefix(address, "#") {
+		address = "#" + address
+	}
+	return address
+}
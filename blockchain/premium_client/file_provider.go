@@ -0,0 +1,159 @@
+package premium_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipoluianov/gomisc/logger"
+)
+
+//////////////////////////////////////////////////////
+// Premium file (JSON)
+// [
+//   {"address": "#pem53...", "expires": "2025-01-01T00:00:00Z"}
+// ]
+// Records without "expires" never expire.
+//////////////////////////////////////////////////////
+
+type PremiumRecord struct {
+	Address string    `json:"address"`
+	Expires time.Time `json:"expires"`
+}
+
+// FileProvider reads premium addresses from the file and reloads it when it changes
+type FileProvider struct {
+	mtx      sync.Mutex
+	fileName string
+	period   time.Duration
+	records  map[string]time.Time
+	modTime  time.Time
+	loadDT   time.Time
+	stopping chan struct{}
+
+	counterSuccess int
+	counterError   int
+}
+
+func NewFileProvider(fileName string, period time.Duration) *FileProvider {
+	var c FileProvider
+	c.fileName = fileName
+	c.period = period
+	c.records = make(map[string]time.Time)
+	c.stopping = make(chan struct{})
+	return &c
+}
+
+func normalizeAddress(xchgAddress string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(xchgAddress), "#"))
+}
+
+func (c *FileProvider) Start() error {
+	c.check()
+	go c.thReload()
+	return nil
+}
+
+func (c *FileProvider) Stop() {
+	close(c.stopping)
+}
+
+func (c *FileProvider) thReload() {
+	ticker := time.NewTicker(c.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.check()
+		case <-c.stopping:
+			return
+		}
+	}
+}
+
+// check reloads the file if it has changed. On errors the previous list is kept.
+func (c *FileProvider) check() {
+	st, err := os.Stat(c.fileName)
+	if err != nil {
+		c.mtx.Lock()
+		c.counterError++
+		c.mtx.Unlock()
+		logger.Println("FileProvider error:", err)
+		return
+	}
+
+	c.mtx.Lock()
+	modTime := c.modTime
+	c.mtx.Unlock()
+	if st.ModTime().Equal(modTime) {
+		return
+	}
+
+	records, err := loadPremiumFile(c.fileName)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if err != nil {
+		c.counterError++
+		logger.Println("FileProvider load error:", err)
+		return
+	}
+	c.records = records
+	c.modTime = st.ModTime()
+	c.loadDT = time.Now()
+	c.counterSuccess++
+	logger.Println("FileProvider loaded", c.fileName, "records:", len(records))
+}
+
+func loadPremiumFile(fileName string) (map[string]time.Time, error) {
+	bs, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var list []PremiumRecord
+	err = json.Unmarshal(bs, &list)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", fileName, err)
+	}
+	records := make(map[string]time.Time)
+	for _, r := range list {
+		records[normalizeAddress(r.Address)] = r.Expires
+	}
+	return records, nil
+}
+
+func (c *FileProvider) IsPremium(xchgAddress string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	expires, ok := c.records[normalizeAddress(xchgAddress)]
+	return ok && (expires.IsZero() || time.Now().Before(expires))
+}
+
+func (c *FileProvider) RecordsCount() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.records)
+}
+
+func (c *FileProvider) Status() (source string, age time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.loadDT.IsZero() {
+		return "none", 0
+	}
+	return "file", time.Since(c.loadDT)
+}
+
+func (c *FileProvider) CounterSuccess() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.counterSuccess
+}
+
+func (c *FileProvider) CounterError() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.counterError
+}
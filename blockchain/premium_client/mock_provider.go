@@ -0,0 +1,63 @@
+package premium_client
+
+import (
+	"sync"
+	"time"
+)
+
+// MockProvider keeps premium addresses in memory. It is used in tests.
+type MockProvider struct {
+	mtx     sync.Mutex
+	records map[string]time.Time
+}
+
+func NewMockProvider() *MockProvider {
+	var c MockProvider
+	c.records = make(map[string]time.Time)
+	return &c
+}
+
+// Set gives premium status to the address until expires (zero - forever)
+func (c *MockProvider) Set(xchgAddress string, expires time.Time) {
+	c.mtx.Lock()
+	c.records[normalizeAddress(xchgAddress)] = expires
+	c.mtx.Unlock()
+}
+
+func (c *MockProvider) Remove(xchgAddress string) {
+	c.mtx.Lock()
+	delete(c.records, normalizeAddress(xchgAddress))
+	c.mtx.Unlock()
+}
+
+func (c *MockProvider) Start() error {
+	return nil
+}
+
+func (c *MockProvider) Stop() {
+}
+
+func (c *MockProvider) IsPremium(xchgAddress string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	expires, ok := c.records[normalizeAddress(xchgAddress)]
+	return ok && (expires.IsZero() || time.Now().Before(expires))
+}
+
+func (c *MockProvider) RecordsCount() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.records)
+}
+
+func (c *MockProvider) Status() (source string, age time.Duration) {
+	return "mock", 0
+}
+
+func (c *MockProvider) CounterSuccess() int {
+	return 0
+}
+
+func (c *MockProvider) CounterError() int {
+	return 0
+}
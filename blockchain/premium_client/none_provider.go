@@ -0,0 +1,39 @@
+package premium_client
+
+import "time"
+
+// NoneProvider gives premium status to no address
+type NoneProvider struct {
+}
+
+func NewNoneProvider() *NoneProvider {
+	var c NoneProvider
+	return &c
+}
+
+func (c *NoneProvider) Start() error {
+	return nil
+}
+
+func (c *NoneProvider) Stop() {
+}
+
+func (c *NoneProvider) IsPremium(xchgAddress string) bool {
+	return false
+}
+
+func (c *NoneProvider) RecordsCount() int {
+	return 0
+}
+
+func (c *NoneProvider) Status() (source string, age time.Duration) {
+	return "none", 0
+}
+
+func (c *NoneProvider) CounterSuccess() int {
+	return 0
+}
+
+func (c *NoneProvider) CounterError() int {
+	return 0
+}
@@ -1,13 +1,19 @@
 package premium_client
 
-type PremiumClient struct {
-}
+import "time"
 
-func NewPremiumClient() *PremiumClient {
-	var c PremiumClient
-	return &c
-}
+// PremiumProvider tells which xchg addresses have premium status.
+// Addresses are passed without "#".
+type PremiumProvider interface {
+	Start() error
+	Stop()
+	IsPremium(xchgAddress string) bool
+	RecordsCount() int
+
+	// Updates of the premium list by result
+	CounterSuccess() int
+	CounterError() int
 
-func (c *PremiumClient) isPremium() bool {
-	return true
+	// Status returns where the premium status comes from and the age of the data
+	Status() (source string, age time.Duration)
 }
@@ -6,24 +6,17 @@ import (
 )
 
 type AddressStorage struct {
-	mtx         sync.Mutex
-	TouchDT     time.Time
-	maxMessages int
-	billingInfo BillingInfo
-	messages    []*Message
+	mtx       sync.Mutex
+	TouchDT   time.Time
+	limits    TierLimits
+	messages  []*Message
+	listeners []chan struct{}
 }
 
-type BillingInfo struct {
-	Counter uint32 `json:"counter"`
-	Limit   uint32 `json:"limit"`
-}
-
-func NewAddressStorage() *AddressStorage {
+func NewAddressStorage(limits TierLimits) *AddressStorage {
 	var c AddressStorage
-	c.maxMessages = 1000
-	c.billingInfo.Limit = 10000
-	c.billingInfo.Counter = 0
-	c.messages = make([]*Message, 0, c.maxMessages+1)
+	c.limits = limits
+	c.messages = make([]*Message, 0)
 	c.TouchDT = time.Now()
 	return &c
 }
@@ -31,22 +24,29 @@ func NewAddressStorage() *AddressStorage {
 func (c *AddressStorage) Clear() {
 	now := time.Now()
 	c.mtx.Lock()
+	lifetime := c.limits.MessageLifetime()
 	oldMessages := c.messages
 	c.messages = make([]*Message, 0, len(oldMessages))
 	for _, m := range oldMessages {
-		if now.Sub(m.TouchDT) < 5*time.Second {
+		if now.Sub(m.TouchDT) < lifetime {
 			c.messages = append(c.messages, m)
 		}
 	}
 	c.mtx.Unlock()
 }
 
-func (c *AddressStorage) GetBillingInfo() BillingInfo {
-	var bi BillingInfo
+// IsIdle reports whether the storage can be evicted
+func (c *AddressStorage) IsIdle(now time.Time) bool {
 	c.mtx.Lock()
-	bi = c.billingInfo
+	defer c.mtx.Unlock()
+	return now.Sub(c.TouchDT) > c.limits.AddressIdleTimeout() && len(c.listeners) == 0
+}
+
+func (c *AddressStorage) Limits() (limits TierLimits) {
+	c.mtx.Lock()
+	limits = c.limits
 	c.mtx.Unlock()
-	return bi
+	return
 }
 
 func (c *AddressStorage) MessagesCount() (count int) {
@@ -56,21 +56,69 @@ func (c *AddressStorage) MessagesCount() (count int) {
 	return
 }
 
-func (c *AddressStorage) Put(id uint64, frame []byte) error {
+func (c *AddressStorage) MessagesSize() (size int64) {
+	c.mtx.Lock()
+	for _, msg := range c.messages {
+		size += int64(len(msg.data))
+	}
+	c.mtx.Unlock()
+	return
+}
+
+// Put stores the frame, the limits of the tier may have changed since the storage was created
+func (c *AddressStorage) Put(msg *Message, limits TierLimits) {
+	now := time.Now()
 	c.mtx.Lock()
-	/*if c.billingInfo.Counter >= c.billingInfo.Limit {
-		c.mtx.Unlock()
-		return errors.New("limit exceeded")
-	}*/
-	c.billingInfo.Counter++
-	msg := NewMessage(id, frame)
+	c.limits = limits
 	c.messages = append(c.messages, msg)
-	if len(c.messages) > c.maxMessages {
+	if len(c.messages) > c.limits.MaxMessages {
 		c.messages = c.messages[1:]
 	}
-	c.TouchDT = time.Now()
+	c.TouchDT = now
+	for _, l := range c.listeners {
+		select {
+		case l <- struct{}{}:
+		default:
+		}
+	}
+	c.mtx.Unlock()
+}
+
+// Restore appends a message loaded from the storage
+func (c *AddressStorage) Restore(msg *Message) {
+	c.mtx.Lock()
+	c.messages = append(c.messages, msg)
+	if len(c.messages) > c.limits.MaxMessages {
+		c.messages = c.messages[1:]
+	}
+	c.mtx.Unlock()
+}
+
+// AddListener returns a channel that is signalled after each Put
+func (c *AddressStorage) AddListener() chan struct{} {
+	l := make(chan struct{}, 1)
+	c.mtx.Lock()
+	c.listeners = append(c.listeners, l)
 	c.mtx.Unlock()
-	return nil
+	return l
+}
+
+func (c *AddressStorage) RemoveListener(l chan struct{}) {
+	c.mtx.Lock()
+	for i, item := range c.listeners {
+		if item == l {
+			c.listeners = append(c.listeners[:i], c.listeners[i+1:]...)
+			break
+		}
+	}
+	c.mtx.Unlock()
+}
+
+func (c *AddressStorage) ListenersCount() (count int) {
+	c.mtx.Lock()
+	count = len(c.listeners)
+	c.mtx.Unlock()
+	return
 }
 
 func (c *AddressStorage) GetMessage(afterId uint64, maxSize uint64) (data []byte, lastId uint64, count int) {
@@ -106,3 +154,35 @@ func (c *AddressStorage) GetMessage(afterId uint64, maxSize uint64) (data []byte
 	c.mtx.Unlock()
 	return
 }
+
+// GetMessagesLimited is GetMessage that never splits the result:
+// messages larger than maxSize are skipped, the rest are returned while they fit
+func (c *AddressStorage) GetMessagesLimited(afterId uint64, maxSize int) (data []byte, lastId uint64, count int, skipped int) {
+	data = make([]byte, 0)
+	lastId = afterId
+	sendAll := false
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if len(c.messages) > 0 && afterId > c.messages[len(c.messages)-1].id {
+		sendAll = true
+	}
+
+	for _, m := range c.messages {
+		if m.id <= afterId && !sendAll {
+			continue
+		}
+		if len(m.data) > maxSize {
+			lastId = m.id
+			skipped++
+			continue
+		}
+		if len(data)+len(m.data) > maxSize {
+			break
+		}
+		data = append(data, m.data...)
+		lastId = m.id
+		count++
+	}
+	return
+}
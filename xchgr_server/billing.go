@@ -0,0 +1,79 @@
+package xchgr_server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Billing counts the frames sent by an address in the billing period.
+// The counters are kept apart from the queues: a queue is evicted after
+// address_idle_timeout_ms, a counter lives until its period is over.
+type Billing struct {
+	mtx      sync.Mutex
+	counters map[string]*BillingInfo
+}
+
+type BillingInfo struct {
+	Counter     uint32    `json:"counter"`
+	Limit       uint32    `json:"limit"`
+	PeriodBegin time.Time `json:"period_begin"`
+}
+
+func NewBilling() *Billing {
+	var c Billing
+	c.counters = make(map[string]*BillingInfo)
+	return &c
+}
+
+// Charge counts a frame if the counter of the current period is below the limit
+func (c *Billing) Charge(address string, limit uint32, period time.Duration, now time.Time) error {
+	return c.ChargeAll(map[string]uint32{address: 1}, map[string]uint32{address: limit}, period, now)
+}
+
+// ChargeAll counts the frames of several addresses at once:
+// nothing is counted if one of the addresses would exceed its limit
+func (c *Billing) ChargeAll(counts map[string]uint32, limits map[string]uint32, period time.Duration, now time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for address, n := range counts {
+		bi, ok := c.counters[address]
+		if !ok || now.Sub(bi.PeriodBegin) >= period {
+			bi = &BillingInfo{PeriodBegin: now}
+			c.counters[address] = bi
+		}
+		bi.Limit = limits[address]
+		if uint64(bi.Counter)+uint64(n) > uint64(bi.Limit) {
+			return ErrLimitExceeded
+		}
+	}
+	for address, n := range counts {
+		c.counters[address].Counter += n
+	}
+	return nil
+}
+
+// Get returns the counter of the current period (zero if there is none)
+func (c *Billing) Get(address string, period time.Duration, now time.Time) (bi BillingInfo) {
+	c.mtx.Lock()
+	counter, ok := c.counters[address]
+	if ok && now.Sub(counter.PeriodBegin) < period {
+		bi = *counter
+	}
+	c.mtx.Unlock()
+	return
+}
+
+// Clear removes the counters of the finished periods
+func (c *Billing) Clear(period time.Duration, now time.Time) {
+	c.mtx.Lock()
+	for address, bi := range c.counters {
+		if now.Sub(bi.PeriodBegin) >= period {
+			delete(c.counters, address)
+		}
+	}
+	c.mtx.Unlock()
+}
@@ -0,0 +1,36 @@
+package xchgr_server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipoluianov/xchgr/blockchain/premium_client"
+)
+
+func TestBatchChargedAsWhole(t *testing.T) {
+	router := newPremiumTestRouter(premium_client.NewMockProvider())
+	limits := router.Limits()
+	limits.FramesPerPeriod = 2
+	router.SetLimits(limits)
+
+	batch := append(premiumTestFrame(0xA0, 0x01), premiumTestFrame(0xA0, 0x02)...)
+	batch = append(batch, premiumTestFrame(0xA0, 0x03)...)
+	err := router.PutFrames(batch, nil)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("batch over the limit: %v", err)
+	}
+	if len(router.addresses) != 0 {
+		t.Errorf("%d queues after a rejected batch", len(router.addresses))
+	}
+	if bi, _ := router.GetBillingInfo(premiumTestAddress(0xA0)); bi.Counter != 0 {
+		t.Errorf("counter %d after a rejected batch", bi.Counter)
+	}
+
+	err = router.PutFrames(batch[:2*FRAME_HEADER_SIZE], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bi, _ := router.GetBillingInfo(premiumTestAddress(0xA0)); bi.Counter != 2 {
+		t.Errorf("counter %d, expected 2", bi.Counter)
+	}
+}
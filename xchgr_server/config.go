@@ -0,0 +1,459 @@
+package xchgr_server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kardianos/osext"
+)
+
+const (
+	NETWORK_SOURCE_DEFAULT  = "default"
+	NETWORK_SOURCE_FILE     = "file"
+	NETWORK_SOURCE_INTERNET = "internet"
+
+	DEFAULT_NETWORK_URL = "https://xchgx.net/network.json"
+)
+
+type Config struct {
+	HttpListeners []string `json:"http_listeners"`
+	// HTTPS listeners, HTTP/2 is negotiated with ALPN
+	TlsListeners []string  `json:"tls_listeners"`
+	Tls          TlsConfig `json:"tls"`
+	// HTTP/2 without TLS (h2c) on http_listeners
+	H2c         bool      `json:"h2c"`
+	UdpListener string    `json:"udp_listener"`
+	Udr         UdrConfig `json:"udr"`
+
+	// Relative paths are relative to the executable folder
+	DataDir string `json:"data_dir"`
+
+	Storage    StorageConfig    `json:"storage"`
+	Network    NetworkConfig    `json:"network"`
+	Premium    PremiumConfig    `json:"premium"`
+	Contract01 Contract01Config `json:"contract01"`
+	Names      NamesConfig      `json:"names"`
+	Forwarding ForwardingConfig `json:"forwarding"`
+	Pow        PowConfig        `json:"pow"`
+	// Sender signatures: off, optional (only signed frames are checked) or required
+	Signatures string           `json:"signatures"`
+	ReadAuth   ReadAuthConfig   `json:"read_auth"`
+	RateLimits RateLimitsConfig `json:"rate_limits"`
+	Limits     Limits           `json:"limits"`
+}
+
+type TlsConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// The files are checked for changes at most once per period
+	ReloadPeriodMs int `json:"reload_period_ms"`
+}
+
+type StorageConfig struct {
+	Type string `json:"type"`
+	// Default: <data_dir>/storage
+	Dir string `json:"dir"`
+}
+
+type NetworkConfig struct {
+	Source string `json:"source"`
+	// For the "file" source. Default: <data_dir>/network.json
+	File string `json:"file"`
+	// For the "internet" source
+	Url string `json:"url"`
+}
+
+// Names are resolved by /api/ns from the zone file (see name_client.FileZone)
+type NamesConfig struct {
+	// Default: <data_dir>/names.json
+	ZoneFile       string `json:"zone_file"`
+	ReloadPeriodMs int    `json:"reload_period_ms"`
+
+	// Self-service registration with /api/ns/register.
+	// Names of the zone file can not be registered.
+	RegistrationEnabled      bool `json:"registration_enabled"`
+	RegistrationLifetimeDays int  `json:"registration_lifetime_days"`
+	RegistrationTTL          int  `json:"registration_ttl"`
+	// Names one address may hold
+	RegistrationMaxPerAddress int `json:"registration_max_per_address"`
+	// Default: <data_dir>/registrations.json
+	RegistryFile string `json:"registry_file"`
+}
+
+type PremiumConfig struct {
+	// contract01, file or none
+	Provider string `json:"provider"`
+	// For the "file" provider. Default: <data_dir>/premium.json
+	File           string `json:"file"`
+	ReloadPeriodMs int    `json:"reload_period_ms"`
+}
+
+type Contract01Config struct {
+	Enabled bool `json:"enabled"`
+	// Empty values are read from url.txt and address.txt in <data_dir>/contract01
+	Url            string `json:"url"`
+	Address        string `json:"address"`
+	UpdatePeriodMs int    `json:"update_period_ms"`
+	// The snapshot of premium addresses is used while the contract can not be reached
+	SnapshotStalenessMs int `json:"snapshot_staleness_ms"`
+}
+
+// Forwarding relays frames for addresses outside of the local ranges of the network map
+type ForwardingConfig struct {
+	Enabled      bool `json:"enabled"`
+	Workers      int  `json:"workers"`
+	QueueSize    int  `json:"queue_size"`
+	Attempts     int  `json:"attempts"`
+	RetryDelayMs int  `json:"retry_delay_ms"`
+	TimeoutMs    int  `json:"timeout_ms"`
+	// SelfHost is the address of this router in the network map (host or host:port).
+	// Empty - the hosts are matched against the IPs of the local interfaces.
+	SelfHost string `json:"self_host"`
+}
+
+type PowConfig struct {
+	// Writers without premium status must attach a proof of work to every write request
+	Enabled    bool `json:"enabled"`
+	Complexity int  `json:"complexity"`
+
+	// Adaptive raises the complexity up to MaxComplexity under load (see PowController)
+	Adaptive            bool  `json:"adaptive"`
+	MaxComplexity       int   `json:"max_complexity"`
+	AdjustPeriodMs      int   `json:"adjust_period_ms"`
+	HighWritesPerSecond int   `json:"high_writes_per_second"`
+	HighQueuedBytes     int64 `json:"high_queued_bytes"`
+	HighCpuPercent      int   `json:"high_cpu_percent"`
+}
+
+func DefaultConfig() Config {
+	var c Config
+	c.HttpListeners = []string{":8084"}
+	c.TlsListeners = []string{}
+	c.Tls.ReloadPeriodMs = 60000
+	c.UdpListener = ":8084"
+	c.Udr.TtlMs = 120000
+	c.Udr.MaxEntries = 100000
+	c.Udr.MaxClockSkewMs = 30000
+	c.Udr.ConnectIntervalMs = 1000
+	c.Udr.MaxDatagramSize = 1200
+	c.Udr.Relay.BandwidthBytesPerSecond = 64 * 1024
+	c.Udr.Relay.BurstBytes = 128 * 1024
+	c.Udr.Relay.IdleTimeoutMs = 60000
+	c.Udr.Relay.MaxAllocations = 10000
+	c.Udr.Relay.MaxAllocationsPerAddress = 16
+	c.Udr.PerIp.Rate = 100
+	c.Udr.PerIp.Burst = 200
+	c.DataDir = "data"
+	c.Storage.Type = STORAGE_TYPE_MEMORY
+	c.Network.Source = NETWORK_SOURCE_DEFAULT
+	c.Network.Url = DEFAULT_NETWORK_URL
+	c.Names.ReloadPeriodMs = 5000
+	c.Names.RegistrationLifetimeDays = 365
+	c.Names.RegistrationTTL = 60
+	c.Names.RegistrationMaxPerAddress = 10
+	c.Premium.Provider = PREMIUM_PROVIDER_CONTRACT01
+	c.Premium.ReloadPeriodMs = 5000
+	c.Contract01.Enabled = true
+	c.Contract01.UpdatePeriodMs = 5000
+	c.Contract01.SnapshotStalenessMs = 24 * 3600 * 1000
+	c.Forwarding.Enabled = false
+	c.Forwarding.Workers = 16
+	c.Forwarding.QueueSize = 10000
+	c.Forwarding.Attempts = 3
+	c.Forwarding.RetryDelayMs = 200
+	c.Forwarding.TimeoutMs = 5000
+	c.Signatures = SIGNATURES_OFF
+	// Behind a reverse proxy every client has the IP of the proxy, see trusted_proxies
+	c.RateLimits.PerIp.Rate = 0
+	c.RateLimits.PerIp.Burst = 400
+	c.RateLimits.PerAddress.Rate = 500
+	c.RateLimits.PerAddress.Burst = 1000
+	c.ReadAuth.Mode = READ_AUTH_OFF
+	c.ReadAuth.TokenLifetimeMs = 10 * 60 * 1000
+	c.ReadAuth.LockLifetimeMs = 24 * 3600 * 1000
+	c.Pow.MaxComplexity = 24
+	c.Pow.AdjustPeriodMs = 5000
+	c.Pow.HighWritesPerSecond = 10000
+	c.Pow.HighQueuedBytes = 512 * 1024 * 1024
+	c.Pow.HighCpuPercent = 80
+	c.Limits = DefaultLimits()
+	return c
+}
+
+// LoadConfig reads the config file. Missing fields keep the default values.
+func LoadConfig(fileName string) (Config, error) {
+	config := DefaultConfig()
+	bs, err := os.ReadFile(fileName)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(bs, &config)
+	if err != nil {
+		return config, fmt.Errorf("parse %s: %v", fileName, err)
+	}
+	return config, nil
+}
+
+func (c Config) String() string {
+	bs, _ := json.MarshalIndent(c, "", " ")
+	return string(bs)
+}
+
+// Check validates the config and returns all found problems
+func (c Config) Check() error {
+	problems := make([]string, 0)
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(c.HttpListeners) == 0 && len(c.TlsListeners) == 0 {
+		addProblem("http_listeners: at least one listener is required")
+	}
+	for _, listener := range c.HttpListeners {
+		if _, _, err := net.SplitHostPort(listener); err != nil {
+			addProblem("http_listeners: %v", err)
+		}
+	}
+	for _, listener := range c.TlsListeners {
+		if _, _, err := net.SplitHostPort(listener); err != nil {
+			addProblem("tls_listeners: %v", err)
+		}
+	}
+	if len(c.TlsListeners) > 0 {
+		if c.Tls.CertFile == "" || c.Tls.KeyFile == "" {
+			addProblem("tls: cert_file and key_file are required for tls_listeners")
+		}
+		if c.Tls.ReloadPeriodMs <= 0 {
+			addProblem("tls.reload_period_ms: must be positive")
+		}
+	}
+	if c.UdpListener != "" {
+		if _, _, err := net.SplitHostPort(c.UdpListener); err != nil {
+			addProblem("udp_listener: %v", err)
+		}
+	}
+	if c.UdpListener != "" && (c.Udr.TtlMs <= 0 || c.Udr.MaxEntries <= 0 || c.Udr.MaxClockSkewMs <= 0) {
+		addProblem("udr: ttl_ms, max_entries and max_clock_skew_ms must be positive")
+	}
+	if c.UdpListener != "" && c.Udr.ConnectIntervalMs < 0 {
+		addProblem("udr.connect_interval_ms: must not be negative")
+	}
+	if c.UdpListener != "" && c.Udr.AltListener != "" {
+		if _, _, err := net.SplitHostPort(c.Udr.AltListener); err != nil {
+			addProblem("udr.alt_listener: %v", err)
+		}
+	}
+	if c.UdpListener != "" && c.Udr.Relay.Enabled {
+		if c.Udr.Relay.BandwidthBytesPerSecond < 0 {
+			addProblem("udr.relay.bandwidth_bytes_per_second: must not be negative")
+		}
+		if c.Udr.Relay.BandwidthBytesPerSecond > 0 && c.Udr.Relay.BurstBytes < c.Udr.MaxDatagramSize {
+			addProblem("udr.relay.burst_bytes: must be at least udr.max_datagram_size")
+		}
+		if c.Udr.Relay.IdleTimeoutMs <= 0 || c.Udr.Relay.MaxAllocations <= 0 || c.Udr.Relay.MaxAllocationsPerAddress <= 0 {
+			addProblem("udr.relay: idle_timeout_ms, max_allocations and max_allocations_per_address must be positive")
+		}
+	}
+	if c.UdpListener != "" && (c.Udr.MaxDatagramSize < UDR_PUSH_HEADER+FRAME_HEADER_SIZE || c.Udr.MaxDatagramSize > UDR_MAX_DATAGRAM_SIZE) {
+		addProblem("udr.max_datagram_size: must be in range [%d, %d]", UDR_PUSH_HEADER+FRAME_HEADER_SIZE, UDR_MAX_DATAGRAM_SIZE)
+	}
+	if c.DataDir == "" {
+		addProblem("data_dir: is empty")
+	}
+
+	switch c.Storage.Type {
+	case STORAGE_TYPE_MEMORY, STORAGE_TYPE_FILE:
+	default:
+		addProblem("storage.type: unknown type %q", c.Storage.Type)
+	}
+
+	switch c.Network.Source {
+	case NETWORK_SOURCE_DEFAULT, NETWORK_SOURCE_FILE:
+	case NETWORK_SOURCE_INTERNET:
+		if c.Network.Url == "" {
+			addProblem("network.url: is empty")
+		}
+	default:
+		addProblem("network.source: unknown source %q", c.Network.Source)
+	}
+
+	if c.Names.ReloadPeriodMs <= 0 {
+		addProblem("names.reload_period_ms: must be positive")
+	}
+	if c.Names.RegistrationEnabled && (c.Names.RegistrationLifetimeDays <= 0 || c.Names.RegistrationTTL <= 0 || c.Names.RegistrationMaxPerAddress <= 0) {
+		addProblem("names: registration_lifetime_days, registration_ttl and registration_max_per_address must be positive")
+	}
+
+	switch c.Premium.Provider {
+	case PREMIUM_PROVIDER_CONTRACT01, PREMIUM_PROVIDER_NONE:
+	case PREMIUM_PROVIDER_FILE:
+		if c.Premium.ReloadPeriodMs <= 0 {
+			addProblem("premium.reload_period_ms: must be positive")
+		}
+	default:
+		addProblem("premium.provider: unknown provider %q", c.Premium.Provider)
+	}
+
+	if c.Contract01.Enabled && c.Contract01.UpdatePeriodMs <= 0 {
+		addProblem("contract01.update_period_ms: must be positive")
+	}
+	if c.Contract01.Enabled && c.Contract01.SnapshotStalenessMs < 0 {
+		addProblem("contract01.snapshot_staleness_ms: must not be negative")
+	}
+
+	if c.Forwarding.Enabled {
+		if c.Forwarding.Workers <= 0 || c.Forwarding.QueueSize <= 0 || c.Forwarding.Attempts <= 0 || c.Forwarding.TimeoutMs <= 0 {
+			addProblem("forwarding: workers, queue_size, attempts and timeout_ms must be positive")
+		}
+		if c.Forwarding.RetryDelayMs < 0 {
+			addProblem("forwarding.retry_delay_ms: must not be negative")
+		}
+	}
+
+	if c.Pow.Complexity < 0 || c.Pow.Complexity > 255 {
+		addProblem("pow.complexity: must be in range [0, 255]")
+	}
+	if c.Pow.Adaptive {
+		if c.Pow.MaxComplexity < c.Pow.Complexity || c.Pow.MaxComplexity > 255 {
+			addProblem("pow.max_complexity: must be in range [pow.complexity, 255]")
+		}
+		if c.Pow.AdjustPeriodMs <= 0 || c.Pow.HighWritesPerSecond <= 0 || c.Pow.HighQueuedBytes <= 0 || c.Pow.HighCpuPercent <= 0 {
+			addProblem("pow: adjust_period_ms and high_* thresholds must be positive")
+		}
+	}
+
+	switch c.Signatures {
+	case SIGNATURES_OFF, SIGNATURES_OPTIONAL, SIGNATURES_REQUIRED:
+	default:
+		addProblem("signatures: unknown mode %q", c.Signatures)
+	}
+
+	switch c.ReadAuth.Mode {
+	case READ_AUTH_OFF:
+	case READ_AUTH_OPTIONAL, READ_AUTH_REQUIRED:
+		if c.ReadAuth.TokenLifetimeMs <= 0 || c.ReadAuth.LockLifetimeMs <= 0 {
+			addProblem("read_auth: token_lifetime_ms and lock_lifetime_ms must be positive")
+		}
+	default:
+		addProblem("read_auth.mode: unknown mode %q", c.ReadAuth.Mode)
+	}
+
+	checkRateLimit := func(name string, r RateLimitConfig) {
+		if r.Rate < 0 {
+			addProblem("%s.rate: must not be negative", name)
+		}
+		if r.Rate > 0 && r.Burst < 1 {
+			addProblem("%s.burst: must be positive", name)
+		}
+	}
+	checkRateLimit("rate_limits.per_ip", c.RateLimits.PerIp)
+	checkRateLimit("rate_limits.per_address", c.RateLimits.PerAddress)
+	checkRateLimit("udr.per_ip", c.Udr.PerIp)
+	for _, proxy := range c.RateLimits.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			addProblem("rate_limits.trusted_proxies: %q is not an IP", proxy)
+		}
+	}
+
+	l := c.Limits
+	if l.MessageLifetimeMs <= 0 || l.AddressIdleTimeoutMs <= 0 || l.MaxMessages <= 0 || l.LongPollingTimeoutMs <= 0 || l.FramesPerPeriod == 0 {
+		addProblem("limits: global values must be positive")
+	}
+	if l.MaxRequestSize <= 0 {
+		addProblem("limits.max_request_size: must be positive")
+	}
+	if l.BillingPeriodMs <= 0 {
+		addProblem("limits.billing_period_ms: must be positive")
+	}
+	if l.Free.MessageLifetimeMs < 0 || l.Free.AddressIdleTimeoutMs < 0 || l.Free.MaxMessages < 0 || l.Free.LongPollingTimeoutMs < 0 {
+		addProblem("limits.free: values must not be negative")
+	}
+	if l.Premium.MessageLifetimeMs < 0 || l.Premium.AddressIdleTimeoutMs < 0 || l.Premium.MaxMessages < 0 || l.Premium.LongPollingTimeoutMs < 0 {
+		addProblem("limits.premium: values must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// CheckFiles loads the files referenced by the config that Check does not read
+func (c Config) CheckFiles() error {
+	if len(c.TlsListeners) > 0 {
+		_, err := tls.LoadX509KeyPair(c.TlsCertPath(), c.TlsKeyPath())
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c Config) path(dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	exePath, _ := osext.ExecutableFolder()
+	return filepath.Join(exePath, dir)
+}
+
+func (c Config) DataPath() string {
+	return c.path(c.DataDir)
+}
+
+func (c Config) StoragePath() string {
+	if c.Storage.Dir != "" {
+		return c.path(c.Storage.Dir)
+	}
+	return filepath.Join(c.DataPath(), "storage")
+}
+
+func (c Config) NetworkFilePath() string {
+	if c.Network.File != "" {
+		return c.path(c.Network.File)
+	}
+	return filepath.Join(c.DataPath(), "network.json")
+}
+
+func (c Config) UdrPath() string {
+	return filepath.Join(c.DataPath(), "udr.json")
+}
+
+func (c Config) NamesZonePath() string {
+	if c.Names.ZoneFile != "" {
+		return c.path(c.Names.ZoneFile)
+	}
+	return filepath.Join(c.DataPath(), "names.json")
+}
+
+func (c Config) NamesRegistryPath() string {
+	if c.Names.RegistryFile != "" {
+		return c.path(c.Names.RegistryFile)
+	}
+	return filepath.Join(c.DataPath(), "registrations.json")
+}
+
+func (c Config) TlsCertPath() string {
+	return c.path(c.Tls.CertFile)
+}
+
+func (c Config) TlsKeyPath() string {
+	return c.path(c.Tls.KeyFile)
+}
+
+func (c Config) PremiumFilePath() string {
+	if c.Premium.File != "" {
+		return c.path(c.Premium.File)
+	}
+	return filepath.Join(c.DataPath(), "premium.json")
+}
+
+func (c Config) Contract01Path() string {
+	return filepath.Join(c.DataPath(), "contract01")
+}
@@ -1,68 +1,212 @@
 package xchgr_server
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/ipoluianov/gazer-billing-contract-eth/api"
 	"github.com/ipoluianov/gomisc/logger"
-	"github.com/kardianos/osext"
 )
 
+//////////////////////////////////////////////////////
+// Premium snapshot (<data_dir>/contract01/snapshot.json)
+// {"updated": time, "addresses": ["pem53...", ...]}
+// updated   - time of the last successful contract update
+// addresses - premium addresses of the contract records
+// The snapshot is used while the contract can not be reached,
+// for at most snapshot_staleness_ms since "updated".
+// The file is written when the addresses change; "updated" alone
+// is written at most once per CONTRACT01_SNAPSHOT_REFRESH.
+//////////////////////////////////////////////////////
+
+const (
+	PREMIUM_SOURCE_CONTRACT = "contract"
+	PREMIUM_SOURCE_SNAPSHOT = "snapshot"
+	PREMIUM_SOURCE_NONE     = "none"
+
+	CONTRACT01_SNAPSHOT_REFRESH = 1 * time.Hour
+)
+
+type contract01Snapshot struct {
+	Updated   time.Time `json:"updated"`
+	Addresses []string  `json:"addresses"`
+}
+
 type Contract01 struct {
+	mtx      sync.Mutex
+	config   Contract01Config
+	dir      string
 	shop     *api.Shop
 	started  bool
 	stopping bool
 
+	// The last contract update was successful
+	online bool
+	// Addresses confirmed as premium and the time of the last successful update
+	snapshot        map[string]bool
+	snapshotDT      time.Time
+	snapshotSavedDT time.Time
+	snapshotChanged bool
+
 	counterSuccess int
 	counterError   int
 }
 
-func NewContract01() *Contract01 {
+func NewContract01(config Contract01Config, dir string) *Contract01 {
 	var c Contract01
+	c.config = config
+	c.dir = dir
+	c.snapshot = make(map[string]bool)
 	return &c
 }
 
 func (c *Contract01) Start() error {
+	if !c.config.Enabled {
+		logger.Println("contract01 disabled")
+		return nil
+	}
+	c.loadSnapshot()
 	go c.tick()
 	return nil
 }
 
-func (c *Contract01) tick() {
-	c.started = true
-	exePath, _ := osext.ExecutableFolder()
-	err := os.MkdirAll(exePath+"/data/contract01", 0777)
+func (c *Contract01) snapshotFile() string {
+	return filepath.Join(c.dir, "snapshot.json")
+}
+
+func (c *Contract01) loadSnapshot() {
+	bs, err := os.ReadFile(c.snapshotFile())
 	if err != nil {
-		logger.Println("make data dir error:", err)
-		c.started = false
+		if !os.IsNotExist(err) {
+			logger.Println("contract01 read snapshot error:", err)
+		}
 		return
 	}
-
-	bsUrl, err := os.ReadFile(exePath + "/data/contract01/url.txt")
+	var snapshot contract01Snapshot
+	err = json.Unmarshal(bs, &snapshot)
 	if err != nil {
-		logger.Println("read url.txt error:", err)
-		c.started = false
+		logger.Println("contract01 parse snapshot error:", err)
 		return
 	}
-	bsContractAddress, err := os.ReadFile(exePath + "/data/contract01/address.txt")
-	if err != nil {
-		logger.Println("read address.txt error:", err)
-		c.started = false
+	c.mtx.Lock()
+	for _, address := range snapshot.Addresses {
+		c.snapshot[address] = true
+	}
+	c.snapshotDT = snapshot.Updated
+	c.snapshotSavedDT = snapshot.Updated
+	c.mtx.Unlock()
+	logger.Println("contract01 snapshot loaded, addresses:", len(snapshot.Addresses), "updated:", snapshot.Updated)
+}
+
+func (c *Contract01) saveSnapshot() {
+	c.mtx.Lock()
+	if !c.snapshotChanged && c.snapshotDT.Sub(c.snapshotSavedDT) < CONTRACT01_SNAPSHOT_REFRESH {
+		c.mtx.Unlock()
 		return
 	}
-	c.shop = api.NewShop(exePath+"/data/contract01/", string(bsUrl), string(bsContractAddress))
-	c.shop.Load()
-	err = c.shop.Update()
+	var snapshot contract01Snapshot
+	snapshot.Updated = c.snapshotDT
+	snapshot.Addresses = make([]string, 0, len(c.snapshot))
+	for address := range c.snapshot {
+		snapshot.Addresses = append(snapshot.Addresses, address)
+	}
+	c.snapshotChanged = false
+	c.snapshotSavedDT = c.snapshotDT
+	c.mtx.Unlock()
+
+	sort.Strings(snapshot.Addresses)
+	bs, _ := json.MarshalIndent(snapshot, "", " ")
+	tmpFile := c.snapshotFile() + ".tmp"
+	err := os.WriteFile(tmpFile, bs, 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, c.snapshotFile())
+	}
+	if err != nil {
+		logger.Println("contract01 write snapshot error:", err)
+	}
+}
+
+func (c *Contract01) update() {
+	err := c.shop.Update()
+	c.mtx.Lock()
 	if err != nil {
 		logger.Println("Update contract01 error:", err)
+		c.online = false
 		c.counterError++
 	} else {
+		c.online = true
+		c.snapshotDT = time.Now()
+		c.updateSnapshot()
 		c.counterSuccess++
 	}
+	c.mtx.Unlock()
+	c.saveSnapshot()
+}
+
+// updateSnapshot replaces the snapshot with the premium addresses of the contract records.
+// c.mtx must be held.
+func (c *Contract01) updateSnapshot() {
+	snapshot := make(map[string]bool)
+	for _, record := range c.shop.Records() {
+		if c.shop.IsPremium(record.Address) {
+			snapshot[record.Address] = true
+		}
+	}
+	changed := len(snapshot) != len(c.snapshot)
+	for address := range snapshot {
+		if !c.snapshot[address] {
+			changed = true
+		}
+	}
+	if changed {
+		c.snapshot = snapshot
+		c.snapshotChanged = true
+	}
+}
+
+func (c *Contract01) tick() {
+	c.started = true
+	err := os.MkdirAll(c.dir, 0777)
+	if err != nil {
+		logger.Println("make data dir error:", err)
+		c.started = false
+		return
+	}
+
+	url := c.config.Url
+	if url == "" {
+		bsUrl, err := os.ReadFile(c.dir + "/url.txt")
+		if err != nil {
+			logger.Println("read url.txt error:", err)
+			c.started = false
+			return
+		}
+		url = string(bsUrl)
+	}
+	contractAddress := c.config.Address
+	if contractAddress == "" {
+		bsContractAddress, err := os.ReadFile(c.dir + "/address.txt")
+		if err != nil {
+			logger.Println("read address.txt error:", err)
+			c.started = false
+			return
+		}
+		contractAddress = string(bsContractAddress)
+	}
+	shop := api.NewShop(c.dir+"/", url, contractAddress)
+	shop.Load()
+	c.mtx.Lock()
+	c.shop = shop
+	c.mtx.Unlock()
+	c.update()
 
 	dtOperationTime := time.Now().UTC()
 
-	periodMs := 5000
+	periodMs := c.config.UpdatePeriodMs
 
 	for !c.stopping {
 		for {
@@ -75,38 +219,82 @@ func (c *Contract01) tick() {
 			break
 		}
 		dtOperationTime = time.Now().UTC()
-		err = c.shop.Update()
-		if err != nil {
-			logger.Println("Update contract01 error:", err)
-			c.counterError++
-		} else {
-			c.counterSuccess++
-		}
-
+		c.update()
 	}
 }
 
 func (c *Contract01) Stop() {
 	c.stopping = true
 	time.Sleep(200 * time.Millisecond)
+	c.saveSnapshot()
 }
 
+// IsPremium asks the contract while it is online and the snapshot otherwise.
+// Without the contract and a fresh snapshot all addresses are free.
 func (c *Contract01) IsPremium(xchgAddress string) bool {
-	return c.shop.IsPremium(xchgAddress)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.shop != nil && c.online {
+		return c.shop.IsPremium(xchgAddress)
+	}
+
+	if c.sourceLocked() == PREMIUM_SOURCE_SNAPSHOT {
+		return c.snapshot[xchgAddress]
+	}
+	return false
+}
+
+func (c *Contract01) sourceLocked() string {
+	if !c.config.Enabled {
+		return PREMIUM_SOURCE_NONE
+	}
+	if c.shop != nil && c.online {
+		return PREMIUM_SOURCE_CONTRACT
+	}
+	if !c.snapshotDT.IsZero() && time.Since(c.snapshotDT) <= time.Duration(c.config.SnapshotStalenessMs)*time.Millisecond {
+		return PREMIUM_SOURCE_SNAPSHOT
+	}
+	return PREMIUM_SOURCE_NONE
+}
+
+// Status returns the source of the premium status and the time since the last successful contract update
+func (c *Contract01) Status() (source string, age time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	source = c.sourceLocked()
+	if !c.snapshotDT.IsZero() {
+		age = time.Since(c.snapshotDT)
+	}
+	return
 }
 
 func (c *Contract01) CounterSuccess() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	return c.counterSuccess
 }
 
 func (c *Contract01) CounterError() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	return c.counterError
 }
 
 func (c *Contract01) RecordsCount() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.shop == nil || !c.online {
+		return len(c.snapshot)
+	}
 	return c.shop.RecordsCount()
 }
 
 func (c *Contract01) Records() []api.ShopRecord {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.shop == nil {
+		return nil
+	}
 	return c.shop.Records()
 }
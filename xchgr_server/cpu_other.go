@@ -0,0 +1,10 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package xchgr_server
+
+import "time"
+
+// processCpuTime is not measured where getrusage is not available (Windows, Plan 9, js/wasm, WASI)
+func processCpuTime() time.Duration {
+	return 0
+}
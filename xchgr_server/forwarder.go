@@ -0,0 +1,177 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipoluianov/gomisc/logger"
+)
+
+const (
+	// Set on frames relayed by another router. Such frames are never forwarded again.
+	HEADER_FORWARDED = "X-Xchg-Forwarded"
+)
+
+var ErrForwardQueueFull = errors.New("forward queue is full")
+
+// Forwarder relays frames to the hosts owning the range of the destination address
+type Forwarder struct {
+	mtx      sync.Mutex
+	config   ForwardingConfig
+	network  *Network
+	client   *http.Client
+	queue    chan *Frame
+	stopping chan struct{}
+	wg       sync.WaitGroup
+
+	counterForwarded int
+	counterErrors    int
+	counterRetries   int
+}
+
+func NewForwarder(config ForwardingConfig, network *Network) *Forwarder {
+	var c Forwarder
+	c.config = config
+	c.network = network
+	c.client = &http.Client{
+		Timeout: time.Duration(config.TimeoutMs) * time.Millisecond,
+	}
+	c.queue = make(chan *Frame, config.QueueSize)
+	c.stopping = make(chan struct{})
+	return &c
+}
+
+func (c *Forwarder) Enabled() bool {
+	return c.config.Enabled
+}
+
+func (c *Forwarder) Start() {
+	if !c.config.Enabled {
+		return
+	}
+	for i := 0; i < c.config.Workers; i++ {
+		c.wg.Add(1)
+		go c.thWorker()
+	}
+}
+
+func (c *Forwarder) Stop() {
+	if !c.config.Enabled {
+		return
+	}
+	close(c.stopping)
+	c.wg.Wait()
+}
+
+// Forward queues the frame for sending. The frame data is copied.
+func (c *Forwarder) Forward(frame *Frame) error {
+	data := make([]byte, len(frame.Data))
+	copy(data, frame.Data)
+	frameCopy, err := ParseFrame(data)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.queue <- frameCopy:
+		return nil
+	default:
+		c.mtx.Lock()
+		c.counterErrors++
+		c.mtx.Unlock()
+		return ErrForwardQueueFull
+	}
+}
+
+func (c *Forwarder) thWorker() {
+	defer c.wg.Done()
+	for {
+		select {
+		case frame := <-c.queue:
+			c.forward(frame)
+		case <-c.stopping:
+			return
+		}
+	}
+}
+
+func (c *Forwarder) forward(frame *Frame) {
+	address := strings.ToLower(base32.StdEncoding.EncodeToString(frame.DestAddress))
+	hosts := c.network.GetNodesAddressesByAddress(address)
+	if len(hosts) == 0 {
+		c.mtx.Lock()
+		c.counterErrors++
+		c.mtx.Unlock()
+		logger.Println("Forwarder no hosts for", address)
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt < c.config.Attempts; attempt++ {
+		if attempt > 0 {
+			c.mtx.Lock()
+			c.counterRetries++
+			c.mtx.Unlock()
+			select {
+			case <-time.After(time.Duration(c.config.RetryDelayMs) * time.Millisecond):
+			case <-c.stopping:
+				return
+			}
+		}
+
+		// Hosts are in random order, every attempt goes to the next host
+		host := hosts[attempt%len(hosts)]
+		var retry bool
+		retry, err = c.send(host, frame)
+		if err == nil {
+			c.mtx.Lock()
+			c.counterForwarded++
+			c.mtx.Unlock()
+			return
+		}
+		if !retry {
+			break
+		}
+	}
+
+	c.mtx.Lock()
+	c.counterErrors++
+	c.mtx.Unlock()
+	logger.Println("Forwarder error:", err)
+}
+
+// send posts the frame to the host. retry is false if the host rejected the frame itself.
+func (c *Forwarder) send(host string, frame *Frame) (retry bool, err error) {
+	req, err := http.NewRequest("POST", "http://"+host+"/api/w", bytes.NewReader(frame.Data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", MIME_OCTET_STREAM)
+	req.Header.Set(HEADER_FORWARDED, "1")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return false, nil
+	}
+	err = fmt.Errorf("host %s: status %d: %s", host, resp.StatusCode, string(body))
+	return resp.StatusCode >= 500, err
+}
+
+func (c *Forwarder) Counters() (forwarded int, errorsCount int, retries int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.counterForwarded, c.counterErrors, c.counterRetries
+}
@@ -0,0 +1,70 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newForwardingTestRouter is a router that does not serve the range "0" of the network
+func newForwardingTestRouter(network *Network) *Router {
+	router := newBenchRouter()
+	config := DefaultConfig().Forwarding
+	config.Enabled = true
+	config.Workers = 1
+	config.RetryDelayMs = 10
+	router.network = network
+	router.forwarder = NewForwarder(config, network)
+	router.localPrefixes = make(map[string]bool)
+	return router
+}
+
+func TestForwardedFramesAreNotForwardedAgain(t *testing.T) {
+	// The map is inconsistent: the range is owned by neither router,
+	// so the receiver would send the frame back without the header
+	network := NewNetwork()
+	receiver := newForwardingTestRouter(network)
+	receiverHttp := NewHttpServer()
+	receiverHttp.server = receiver
+	server := httptest.NewServer(http.HandlerFunc(receiverHttp.processW))
+	defer server.Close()
+	network.AddHostToRange("0", strings.TrimPrefix(server.URL, "http://"))
+
+	sender := newForwardingTestRouter(network)
+	sender.forwarder.Start()
+	defer sender.forwarder.Stop()
+
+	frame := benchFrame(0)
+	address := addressKey(frame[FRAME_DEST_ADDRESS_POS : FRAME_DEST_ADDRESS_POS+AddressBytesSize])
+	if err := sender.PutFrames(frame, nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for storedMessages(receiver, address) == 0 {
+		if time.Now().After(deadline) {
+			forwarded, errorsCount, _ := sender.forwarder.Counters()
+			t.Fatalf("the frame is not stored by the receiver, forwarded %d, errors %d", forwarded, errorsCount)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if storedMessages(sender, address) != 0 {
+		t.Error("the forwarded frame is stored by the sender")
+	}
+	if len(receiver.forwarder.queue) != 0 {
+		t.Error("the receiver forwards the frame again")
+	}
+
+	// The header from a host out of the network map is ignored
+	r := httptest.NewRequest("POST", "/api/w", bytes.NewReader(frame))
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("Content-Type", MIME_OCTET_STREAM)
+	r.Header.Set(HEADER_FORWARDED, "1")
+	w := httptest.NewRecorder()
+	receiverHttp.processW(w, r)
+	if w.Code != http.StatusOK || len(receiver.forwarder.queue) != 1 {
+		t.Errorf("status %d, %d frames queued for forwarding", w.Code, len(receiver.forwarder.queue))
+	}
+}
@@ -0,0 +1,106 @@
+package xchgr_server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//////////////////////////////////////////////////////
+// Frame header
+// Size: 128 bytes
+// [0:4]    frame length including the header (LE)
+// [8]      frame type, 0x80-0xFF are reserved for the router
+// [9]      flags (see signature.go)
+// [40:70]  source address
+// [70:100] destination address
+// The rest of the header is not used by the router
+//////////////////////////////////////////////////////
+
+const (
+	FRAME_HEADER_SIZE      = 128
+	FRAME_MAX_SIZE         = INPUT_BUFFER_SIZE
+	FRAME_TYPE_POS         = 8
+	FRAME_SRC_ADDRESS_POS  = 40
+	FRAME_DEST_ADDRESS_POS = 70
+	FRAME_TYPE_MAX         = 0x7F
+)
+
+var ErrMalformedFrame = errors.New("malformed frame")
+
+type Frame struct {
+	Type        byte
+	SrcAddress  []byte
+	DestAddress []byte
+	Data        []byte
+
+	// The source address is proved by the signature (see signature.go)
+	verified bool
+}
+
+// ParseFrame parses and validates a single frame. data must contain exactly one frame.
+func ParseFrame(data []byte) (*Frame, error) {
+	if len(data) < FRAME_HEADER_SIZE {
+		return nil, fmt.Errorf("%w: size %d is less than header size %d", ErrMalformedFrame, len(data), FRAME_HEADER_SIZE)
+	}
+
+	frameLen := int(binary.LittleEndian.Uint32(data[0:]))
+	if frameLen != len(data) {
+		return nil, fmt.Errorf("%w: declared length %d does not match size %d", ErrMalformedFrame, frameLen, len(data))
+	}
+	if frameLen > FRAME_MAX_SIZE {
+		return nil, fmt.Errorf("%w: length %d exceeds %d", ErrMalformedFrame, frameLen, FRAME_MAX_SIZE)
+	}
+
+	var c Frame
+	c.Data = data
+	c.Type = data[FRAME_TYPE_POS]
+	c.SrcAddress = data[FRAME_SRC_ADDRESS_POS : FRAME_SRC_ADDRESS_POS+AddressBytesSize]
+	c.DestAddress = data[FRAME_DEST_ADDRESS_POS : FRAME_DEST_ADDRESS_POS+AddressBytesSize]
+
+	if c.Type > FRAME_TYPE_MAX {
+		return nil, fmt.Errorf("%w: reserved type 0x%02X", ErrMalformedFrame, c.Type)
+	}
+	if isZeroAddress(c.DestAddress) {
+		return nil, fmt.Errorf("%w: empty destination address", ErrMalformedFrame)
+	}
+	return &c, nil
+}
+
+// NextFrame parses the first frame of a batch of length-prefixed frames.
+// A truncated frame at the end of a batch is an error like any other malformed frame,
+// so the whole batch is rejected (it used to be dropped silently).
+func NextFrame(data []byte) (frame *Frame, rest []byte, err error) {
+	if len(data) < 4 {
+		err = fmt.Errorf("%w: truncated length prefix", ErrMalformedFrame)
+		return
+	}
+	frameLen := int(binary.LittleEndian.Uint32(data[0:]))
+	if frameLen < FRAME_HEADER_SIZE || frameLen > len(data) {
+		err = fmt.Errorf("%w: length %d is out of range [%d, %d]", ErrMalformedFrame, frameLen, FRAME_HEADER_SIZE, len(data))
+		return
+	}
+	frame, err = ParseFrame(data[:frameLen])
+	if err != nil {
+		return
+	}
+	rest = data[frameLen:]
+	return
+}
+
+func (c *Frame) SrcAddressString() string {
+	return addressKey(c.SrcAddress)
+}
+
+func (c *Frame) DestAddressString() string {
+	return addressKey(c.DestAddress)
+}
+
+func isZeroAddress(address []byte) bool {
+	for _, b := range address {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
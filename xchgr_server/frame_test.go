@@ -0,0 +1,37 @@
+package xchgr_server
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func FuzzNextFrame(f *testing.F) {
+	frame := make([]byte, FRAME_HEADER_SIZE+4)
+	binary.LittleEndian.PutUint32(frame[0:], uint32(len(frame)))
+	frame[FRAME_DEST_ADDRESS_POS] = 1
+	f.Add(frame)
+	f.Add(append(append([]byte{}, frame...), frame...))
+	f.Add(frame[:FRAME_HEADER_SIZE])
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for len(data) > 0 {
+			frame, rest, err := NextFrame(data)
+			if err != nil {
+				return
+			}
+			frameLen := int(binary.LittleEndian.Uint32(frame.Data[0:]))
+			if frameLen != len(frame.Data) || frameLen < FRAME_HEADER_SIZE || frameLen > FRAME_MAX_SIZE {
+				t.Fatalf("wrong frame length %d, data %d", frameLen, len(frame.Data))
+			}
+			if len(rest) != len(data)-frameLen {
+				t.Fatalf("rest %d, expected %d", len(rest), len(data)-frameLen)
+			}
+			if frame.Type > FRAME_TYPE_MAX || isZeroAddress(frame.DestAddress) {
+				t.Fatalf("invalid frame accepted")
+			}
+			data = rest
+		}
+	})
+}
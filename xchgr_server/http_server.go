@@ -2,31 +2,44 @@ package xchgr_server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ipoluianov/gomisc/logger"
 	"github.com/ipoluianov/xchgr/blockchain/name_client"
-	"github.com/ipoluianov/xchgr/blockchain/premium_client"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	MIME_OCTET_STREAM = "application/octet-stream"
+	HEADER_POW        = "X-Xchg-Pow"
 )
 
 type HttpServer struct {
-	srv                  *http.Server
-	r                    *mux.Router
-	server               *Router
-	nameClient           *name_client.NameClient
-	premiumClient        *premium_client.PremiumClient
-	longPollingTimeout   time.Duration
-	longPollingTickDelay time.Duration
+	srvs         []*http.Server
+	r            *mux.Router
+	server       *Router
+	nameClient   *name_client.NameClient
+	nameZone     *name_client.FileZone
+	nameRegistry *name_client.Registry
+	namesConfig  NamesConfig
+
+	trustedProxies map[string]bool
 }
 
 func CurrentExePath() string {
@@ -36,35 +49,102 @@ func CurrentExePath() string {
 
 func NewHttpServer() *HttpServer {
 	var c HttpServer
-	c.longPollingTimeout = 10 * time.Second
-	c.longPollingTickDelay = 10 * time.Millisecond
-	c.nameClient = name_client.NewNameClient()
-	c.premiumClient = premium_client.NewPremiumClient()
 	return &c
 }
 
-func (c *HttpServer) Start(server *Router, port int) {
+func (c *HttpServer) Start(server *Router, config Config) error {
 	c.server = server
+	c.namesConfig = config.Names
+	c.trustedProxies = make(map[string]bool)
+	for _, proxy := range config.RateLimits.TrustedProxies {
+		c.trustedProxies[net.ParseIP(proxy).String()] = true
+	}
+	c.nameZone = name_client.NewFileZone(config.NamesZonePath(), time.Duration(config.Names.ReloadPeriodMs)*time.Millisecond)
+	c.nameClient = name_client.NewNameClient(c.nameZone)
+	if config.Names.RegistrationEnabled {
+		var err error
+		c.nameRegistry, err = name_client.NewRegistry(config.NamesRegistryPath(), config.Names.RegistrationTTL, config.Names.RegistrationMaxPerAddress)
+		if err != nil {
+			// The API is served without the registration
+			logger.Println("HttpServer name registration is disabled:", err)
+			c.nameRegistry = nil
+		} else {
+			c.nameClient = name_client.NewNameClient(c.nameZone, c.nameRegistry)
+		}
+	}
 
 	c.r = mux.NewRouter()
 	c.r.HandleFunc("/api/w", c.processW)
 	c.r.HandleFunc("/api/r", c.processR)
+	c.r.HandleFunc("/api/ws", c.processWS)
+	c.r.HandleFunc("/api/nonce", c.processNonce)
+	c.r.HandleFunc("/api/auth", c.processAuth)
 	c.r.HandleFunc("/api/ns", c.processNS)
+	c.r.HandleFunc("/api/ns/register", c.processNSRegister)
 	c.r.HandleFunc("/api/udp", c.processUDP)
+	c.r.HandleFunc("/api/nat", c.processNAT)
 	c.r.HandleFunc("/api/debug", c.processDebug)
 	c.r.HandleFunc("/api/stat", c.processStat)
 	c.r.HandleFunc("/api/billing", c.processBilling)
+	c.r.HandleFunc("/metrics", c.processMetrics)
 	c.r.NotFoundHandler = http.HandlerFunc(c.processFile)
-	c.srv = &http.Server{
-		Addr: ":" + fmt.Sprint(port),
+
+	handler := c.rateLimit(c.r)
+
+	// Without the certificate only the TLS listeners are not started
+	var tlsConfig *tls.Config
+	var tlsErr error
+	if len(config.TlsListeners) > 0 {
+		var certReloader *CertReloader
+		certReloader, tlsErr = NewCertReloader(config.TlsCertPath(), config.TlsKeyPath(), time.Duration(config.Tls.ReloadPeriodMs)*time.Millisecond)
+		if tlsErr == nil {
+			tlsConfig = &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: certReloader.GetCertificate,
+			}
+		}
 	}
 
-	c.srv.Handler = c.r
-	go c.thListen()
+	c.srvs = make([]*http.Server, 0, len(config.HttpListeners)+len(config.TlsListeners))
+	for _, listener := range config.HttpListeners {
+		srv := &http.Server{
+			Addr: listener,
+		}
+		srv.Handler = handler
+		if config.H2c {
+			srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+		c.srvs = append(c.srvs, srv)
+		go c.thListen(srv)
+	}
+	if tlsErr != nil {
+		if len(c.srvs) == 0 {
+			return fmt.Errorf("no listener can be started: %v", tlsErr)
+		}
+		logger.Println("HttpServer TLS listeners are not started:", tlsErr)
+		return nil
+	}
+	for _, listener := range config.TlsListeners {
+		srv := &http.Server{
+			Addr:      listener,
+			TLSConfig: tlsConfig,
+		}
+		srv.Handler = handler
+		c.srvs = append(c.srvs, srv)
+		go c.thListen(srv)
+	}
+	return nil
 }
 
-func (c *HttpServer) thListen() {
-	err := c.srv.ListenAndServe()
+func (c *HttpServer) thListen(srv *http.Server) {
+	logger.Println("HttpServer thListen", srv.Addr)
+	var err error
+	if srv.TLSConfig != nil {
+		// The certificate is provided by TLSConfig.GetCertificate
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != nil {
 		logger.Println("HttpServer thListen error: ", err)
 	}
@@ -76,8 +156,11 @@ func (c *HttpServer) Stop() error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	if err = c.srv.Shutdown(ctx); err != nil {
-		logger.Println(err)
+	for _, srv := range c.srvs {
+		if errShutdown := srv.Shutdown(ctx); errShutdown != nil {
+			logger.Println(errShutdown)
+			err = errShutdown
+		}
 	}
 	return err
 }
@@ -96,8 +179,21 @@ func (c *HttpServer) processStat(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(result)
 }
 
-func (c *HttpServer) processR(w http.ResponseWriter, r *http.Request) {
-	c.server.DeclareHttpRequestR()
+func (c *HttpServer) processNonce(w http.ResponseWriter, r *http.Request) {
+	c.server.DeclareHttpRequestN()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	nonce := c.server.NextNonce()
+	if acceptsBinary(r) {
+		w.Header().Set("Content-Type", MIME_OCTET_STREAM)
+		_, _ = w.Write(nonce[:])
+		return
+	}
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(nonce[:])))
+}
+
+func (c *HttpServer) processAuth(w http.ResponseWriter, r *http.Request) {
+	c.server.DeclareHttpRequestA()
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if r.Method == "OPTIONS" {
@@ -106,18 +202,50 @@ func (c *HttpServer) processR(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method == "POST" {
-		if err := r.ParseMultipartForm(1000000); err != nil {
-			fmt.Fprintf(w, "ParseForm() err: %v", err)
-			return
-		}
+	dataBS, err := c.readFrameData(w, r)
+	if err != nil {
+		w.WriteHeader(400)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
 	}
 
-	data64 := r.FormValue("d")
-	var dataBS []byte
-	var err error
-	dataBS, err = base64.StdEncoding.DecodeString(data64)
+	token, err := c.server.Authorize(dataBS)
 	if err != nil {
+		w.WriteHeader(403)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
+	}
+
+	if acceptsBinary(r) {
+		w.Header().Set("Content-Type", MIME_OCTET_STREAM)
+		_, _ = w.Write(token)
+		return
+	}
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(token)))
+}
+
+func (c *HttpServer) processMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(c.server.Metrics())
+}
+
+func (c *HttpServer) processR(w http.ResponseWriter, r *http.Request) {
+	c.server.DeclareHttpRequestR()
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Request-Method", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	}
+
+	dataBS, err := c.readFrameData(w, r)
+	if err != nil {
+		w.WriteHeader(500)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
 		return
 	}
 
@@ -126,31 +254,42 @@ func (c *HttpServer) processR(w http.ResponseWriter, r *http.Request) {
 	//addrTemp = strings.ToLower(addrTemp)
 
 	var resultBS []byte
-	beginLongPollingDT := time.Now()
-	for time.Since(beginLongPollingDT) < c.longPollingTimeout {
+	addressStorage, listener, err := c.server.Subscribe(dataBS)
+	if err != nil {
+		writeReadError(w, err)
+		return
+	}
+	timer := time.NewTimer(addressStorage.Limits().LongPollingTimeout())
+	waiting := true
+	for waiting {
 		var count int
 		resultBS, count, err = c.server.GetMessages(dataBS)
 		if count > 0 || err != nil {
 			break
 		}
-		if errors.Is(r.Context().Err(), context.Canceled) {
-			break
+		select {
+		case <-listener:
+		case <-timer.C:
+			waiting = false
+		case <-r.Context().Done():
+			waiting = false
 		}
-		time.Sleep(c.longPollingTickDelay)
 	}
+	timer.Stop()
+	c.server.Unsubscribe(addressStorage, listener)
 	if err != nil {
+		writeReadError(w, err)
 		return
 	}
-	resultStr := base64.StdEncoding.EncodeToString(resultBS)
 
-	result := []byte(resultStr)
-	if err != nil {
-		w.WriteHeader(500)
-		b := []byte(err.Error())
-		_, _ = w.Write(b)
+	if acceptsBinary(r) {
+		w.Header().Set("Content-Type", MIME_OCTET_STREAM)
+		_, _ = w.Write(resultBS)
 		return
 	}
-	_, _ = w.Write([]byte(result))
+
+	resultStr := base64.StdEncoding.EncodeToString(resultBS)
+	_, _ = w.Write([]byte(resultStr))
 }
 
 func (c *HttpServer) processBilling(w http.ResponseWriter, r *http.Request) {
@@ -164,7 +303,7 @@ func (c *HttpServer) processBilling(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "POST" {
-		if err := r.ParseMultipartForm(1000000); err != nil {
+		if err := r.ParseMultipartForm(c.server.Limits().MaxRequestSize); err != nil {
 			fmt.Fprintf(w, "ParseForm() err: %v", err)
 			return
 		}
@@ -196,69 +335,190 @@ func (c *HttpServer) processW(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if r.Method == "OPTIONS" {
 		w.Header().Set("Access-Control-Request-Method", "POST")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+HEADER_POW)
 		return
 	}
 
-	if r.Method == "POST" {
-		if err := r.ParseMultipartForm(1000000); err != nil {
-			fmt.Fprintf(w, "ParseForm() err: %v", err)
+	dataBS, err := c.readFrameData(w, r)
+	if err != nil {
+		w.WriteHeader(500)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
+	}
+
+	if r.Header.Get(HEADER_FORWARDED) != "" && c.server.IsNetworkHost(remoteIP(r)) {
+		err = c.server.PutForwardedFrames(dataBS)
+	} else {
+		var pow []byte
+		pow, err = readPow(r)
+		if err != nil {
+			w.WriteHeader(400)
+			b := []byte(err.Error())
+			_, _ = w.Write(b)
 			return
 		}
+		err = c.server.PutFrames(dataBS, pow)
+	}
+	if writeRateLimitError(w, err) {
+		return
+	}
+	if errors.Is(err, ErrPowRequired) || errors.Is(err, ErrPowInvalid) ||
+		errors.Is(err, ErrSignatureRequired) || errors.Is(err, ErrSignatureInvalid) {
+		w.WriteHeader(403)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
+	}
+	if errors.Is(err, ErrMalformedFrame) {
+		w.WriteHeader(400)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
+	}
+	if errors.Is(err, ErrOverBurst) {
+		w.WriteHeader(413)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
+	}
+	if errors.Is(err, ErrLimitExceeded) {
+		w.WriteHeader(402)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
 	}
-
-	data64 := r.FormValue("d")
-	var dataBS []byte
-	var err error
-	dataBS, err = base64.StdEncoding.DecodeString(data64)
 	if err != nil {
 		w.WriteHeader(500)
 		b := []byte(err.Error())
 		_, _ = w.Write(b)
 		return
 	}
+}
 
-	offset := 0
-	for offset < len(dataBS) {
-		if offset+128 <= len(dataBS) {
-			frameLen := int(binary.LittleEndian.Uint32(dataBS[offset:]))
-			if offset+frameLen <= len(dataBS) {
-				err = c.server.Put(dataBS[offset : offset+frameLen])
-				if err != nil {
-					w.WriteHeader(500)
-					b := []byte(err.Error())
-					_, _ = w.Write(b)
-					return
-				}
-			} else {
-				break
+func (c *HttpServer) processNS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	c.server.DeclareHttpRequestNS()
+
+	var result interface{}
+	var ttl int
+	var err error
+	if address := r.FormValue("address"); address != "" {
+		var records []name_client.Record
+		records, err = c.nameClient.ReverseLookup(address)
+		for _, record := range records {
+			if ttl == 0 || record.TTL < ttl {
+				ttl = record.TTL
 			}
-			offset += frameLen
-		} else {
-			break
 		}
+		result = records
+	} else {
+		var record name_client.Record
+		record, err = c.nameClient.Resolve(r.FormValue("name"))
+		ttl = record.TTL
+		result = record
+	}
+	if errors.Is(err, name_client.ErrNotFound) {
+		w.WriteHeader(404)
+		b := []byte(err.Error())
+		_, _ = w.Write(b)
+		return
 	}
-
 	if err != nil {
 		w.WriteHeader(500)
 		b := []byte(err.Error())
 		_, _ = w.Write(b)
 		return
 	}
+
+	bs, _ := json.MarshalIndent(result, "", " ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(ttl))
+	_, _ = w.Write(bs)
 }
 
-func (c *HttpServer) processNS(w http.ResponseWriter, r *http.Request) {
+func (c *HttpServer) processNSRegister(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	name := r.FormValue("name")
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Request-Method", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	}
 	c.server.DeclareHttpRequestNS()
-	result, err := c.nameClient.GetAddressByName(name)
-	if err != nil {
-		w.WriteHeader(500)
+
+	writeError := func(code int, err error) {
+		w.WriteHeader(code)
 		b := []byte(err.Error())
 		_, _ = w.Write(b)
+	}
+
+	if c.nameRegistry == nil {
+		writeError(404, errors.New("name registration is disabled"))
 		return
 	}
-	_, _ = w.Write([]byte(result))
+	if r.Method != "POST" {
+		writeError(405, errors.New("POST required"))
+		return
+	}
+
+	requestBS, err := io.ReadAll(http.MaxBytesReader(w, r.Body, c.server.Limits().MaxRequestSize))
+	if err != nil {
+		writeError(400, err)
+		return
+	}
+	claim, owner, err := c.server.VerifyNameClaim(requestBS)
+	if errors.Is(err, ErrNameClaimMalformed) {
+		writeError(400, err)
+		return
+	}
+	if err != nil {
+		writeError(403, err)
+		return
+	}
+	if _, errZone := c.nameZone.Resolve(claim.Name); errZone == nil {
+		writeError(409, errors.New("name is reserved"))
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(time.Duration(c.namesConfig.RegistrationLifetimeDays) * 24 * time.Hour)
+	var registration name_client.Registration
+	switch claim.Op {
+	case NAME_OP_REGISTER:
+		registration, err = c.nameRegistry.Register(claim.Name, owner, expires, now)
+	case NAME_OP_RENEW:
+		registration, err = c.nameRegistry.Renew(claim.Name, owner, expires, now)
+	case NAME_OP_TRANSFER:
+		registration, err = c.nameRegistry.Transfer(claim.Name, owner, claim.To, now)
+	case NAME_OP_RELEASE:
+		err = c.nameRegistry.Release(claim.Name, owner, now)
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, name_client.ErrInvalidName):
+		writeError(400, err)
+		return
+	case errors.Is(err, name_client.ErrNotOwner), errors.Is(err, name_client.ErrTooManyNames):
+		writeError(403, err)
+		return
+	case errors.Is(err, name_client.ErrNotFound):
+		writeError(404, err)
+		return
+	case errors.Is(err, name_client.ErrNameTaken):
+		writeError(409, err)
+		return
+	default:
+		writeError(500, err)
+		return
+	}
+
+	if claim.Op == NAME_OP_RELEASE {
+		return
+	}
+	bs, _ := json.MarshalIndent(registration, "", " ")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bs)
 }
 
 func (c *HttpServer) processUDP(w http.ResponseWriter, r *http.Request) {
@@ -267,6 +527,140 @@ func (c *HttpServer) processUDP(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte(result))
 }
 
+func (c *HttpServer) processNAT(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	address := r.FormValue("address")
+	info, ok := c.server.udr.NatInfo(address)
+	if address == "" || !ok {
+		w.WriteHeader(404)
+		b := []byte("address is not registered")
+		_, _ = w.Write(b)
+		return
+	}
+	bs, _ := json.MarshalIndent(info, "", " ")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bs)
+}
+
+// readFrameData returns the request payload: the raw body for application/octet-stream
+// or the base64 field "d" of the form for browser clients
+func (c *HttpServer) readFrameData(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxRequestSize := c.server.Limits().MaxRequestSize
+	if r.Method == "POST" && isBinaryRequest(r) {
+		return io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	}
+
+	if r.Method == "POST" {
+		if err := r.ParseMultipartForm(maxRequestSize); err != nil {
+			return nil, fmt.Errorf("ParseForm() err: %v", err)
+		}
+	}
+
+	return base64.StdEncoding.DecodeString(r.FormValue("d"))
+}
+
+// rateLimit applies the per IP rate limit to every request
+func (c *HttpServer) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if writeRateLimitError(w, c.server.AllowIp(c.clientIP(r))) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeReadError writes the status of a failed /api/r request
+func writeReadError(w http.ResponseWriter, err error) {
+	if writeRateLimitError(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrReadTokenRequired), errors.Is(err, ErrReadTokenInvalid):
+		w.WriteHeader(401)
+	case errors.Is(err, ErrMalformedFrame):
+		w.WriteHeader(400)
+	default:
+		w.WriteHeader(500)
+	}
+	b := []byte(err.Error())
+	_, _ = w.Write(b)
+}
+
+// writeRateLimitError writes 429 with Retry-After if err is a RateLimitError
+func writeRateLimitError(w http.ResponseWriter, err error) bool {
+	var rateLimitError *RateLimitError
+	if !errors.As(err, &rateLimitError) {
+		return false
+	}
+	retryAfter := int(math.Ceil(rateLimitError.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(err.Error()))
+	return true
+}
+
+// readPow returns the proof of work from the X-Xchg-Pow header or the form field "p" (base64)
+func readPow(r *http.Request) ([]byte, error) {
+	pow64 := r.Header.Get(HEADER_POW)
+	if pow64 == "" {
+		pow64 = r.FormValue("p")
+	}
+	if pow64 == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(pow64)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP is the remote IP or, for a trusted proxy, the last IP
+// of X-Forwarded-For that is not a trusted proxy
+func (c *HttpServer) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !c.trustedProxies[ip] {
+		return ip
+	}
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwardedFor[i]))
+		if forwardedIP == nil {
+			break
+		}
+		ip = forwardedIP.String()
+		if !c.trustedProxies[ip] {
+			break
+		}
+	}
+	return ip
+}
+
+func isBinaryRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == MIME_OCTET_STREAM
+}
+
+// acceptsBinary reports whether the client explicitly asks for application/octet-stream
+func acceptsBinary(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, item := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(item))
+			if err == nil && mediaType == MIME_OCTET_STREAM {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func SplitRequest(path string) []string {
 	return strings.FieldsFunc(path, func(r rune) bool {
 		return r == '/'
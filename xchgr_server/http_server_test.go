@@ -0,0 +1,106 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipoluianov/xchgr/blockchain/name_client"
+)
+
+func readStatus(httpServer *HttpServer, request []byte) int {
+	r := httptest.NewRequest("POST", "/api/r", bytes.NewReader(request))
+	r.Header.Set("Content-Type", MIME_OCTET_STREAM)
+	w := httptest.NewRecorder()
+	httpServer.processR(w, r)
+	return w.Code
+}
+
+func TestReadErrorStatus(t *testing.T) {
+	router := newBenchRouter()
+	router.addressLimiter = NewRateLimiter(RateLimitConfig{Rate: 1, Burst: 1})
+	httpServer := NewHttpServer()
+	httpServer.server = router
+
+	if code := readStatus(httpServer, make([]byte, 10)); code != http.StatusBadRequest {
+		t.Errorf("short request: %d", code)
+	}
+	// No frames: the request waits for the long polling timeout
+	limits := router.Limits()
+	limits.LongPollingTimeoutMs = 1
+	router.SetLimits(limits)
+	request := benchReadRequest(1)
+	if code := readStatus(httpServer, request); code != http.StatusOK {
+		t.Errorf("read: %d", code)
+	}
+	if code := readStatus(httpServer, request); code != http.StatusTooManyRequests {
+		t.Errorf("read over the rate limit: %d", code)
+	}
+}
+
+func TestNameService(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "names.json")
+	writeZone := func(content string, modTime time.Time) {
+		writeTestFile(t, zoneFile, []byte(content), modTime)
+	}
+	modTime := time.Now().Add(-time.Hour)
+	writeZone(`{"ttl": 120, "records": [
+		{"name": "Work.xchg", "address": "PEM53KA2", "ttl": 60},
+		{"name": "home.xchg", "address": "#pem53ka2"}
+	]}`, modTime)
+
+	httpServer := NewHttpServer()
+	httpServer.server = newBenchRouter()
+	httpServer.nameZone = name_client.NewFileZone(zoneFile, 0)
+	httpServer.nameClient = name_client.NewNameClient(httpServer.nameZone)
+	ns := func(key string, value string, result interface{}) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/ns?"+url.Values{key: {value}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		httpServer.processNS(w, r)
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+
+	// Names and addresses are case-insensitive
+	var record name_client.Record
+	w := ns("name", "WORK.xchg", &record)
+	if w.Code != http.StatusOK || record.Address != "#pem53ka2" || w.Header().Get("Cache-Control") != "max-age=60" {
+		t.Errorf("resolve: %d %+v %s", w.Code, record, w.Header().Get("Cache-Control"))
+	}
+	var records []name_client.Record
+	w = ns("address", "#PEM53KA2", &records)
+	if w.Code != http.StatusOK || len(records) != 2 || records[0].Name != "home.xchg" || records[0].TTL != 120 || w.Header().Get("Cache-Control") != "max-age=60" {
+		t.Errorf("reverse lookup: %d %+v %s", w.Code, records, w.Header().Get("Cache-Control"))
+	}
+	if w = ns("name", "unknown.xchg", &record); w.Code != http.StatusNotFound {
+		t.Errorf("unknown name: %d", w.Code)
+	}
+
+	// The changed zone file is loaded, a broken one keeps the previous zone
+	modTime = modTime.Add(time.Minute)
+	writeZone(`{"records": [{"name": "work.xchg", "address": "#abc"}]}`, modTime)
+	if w = ns("name", "work.xchg", &record); w.Code != http.StatusOK || record.Address != "#abc" {
+		t.Errorf("resolve after the reload: %d %+v", w.Code, record)
+	}
+	modTime = modTime.Add(time.Minute)
+	writeZone(`{"records": [`, modTime)
+	if w = ns("name", "work.xchg", &record); w.Code != http.StatusOK || record.Address != "#abc" {
+		t.Errorf("resolve after a broken zone file: %d %+v", w.Code, record)
+	}
+	if err := os.Remove(zoneFile); err != nil {
+		t.Fatal(err)
+	}
+	if w = ns("name", "work.xchg", &record); w.Code != http.StatusOK || record.Address != "#abc" {
+		t.Errorf("resolve after the zone file is removed: %d %+v", w.Code, record)
+	}
+}
@@ -0,0 +1,87 @@
+package xchgr_server
+
+import "time"
+
+// TierLimits are the limits applied to a single address.
+// In Limits.Free and Limits.Premium a zero value means "use the global value".
+type TierLimits struct {
+	MessageLifetimeMs    int    `json:"message_lifetime_ms"`
+	AddressIdleTimeoutMs int    `json:"address_idle_timeout_ms"`
+	MaxMessages          int    `json:"max_messages"`
+	LongPollingTimeoutMs int    `json:"long_polling_timeout_ms"`
+	FramesPerPeriod      uint32 `json:"frames_per_period"`
+}
+
+type Limits struct {
+	TierLimits
+
+	MaxRequestSize  int64 `json:"max_request_size"`
+	BillingPeriodMs int   `json:"billing_period_ms"`
+
+	Free    TierLimits `json:"free"`
+	Premium TierLimits `json:"premium"`
+}
+
+func DefaultLimits() Limits {
+	var c Limits
+	c.MessageLifetimeMs = 5000
+	c.AddressIdleTimeoutMs = 30000
+	c.MaxMessages = 1000
+	c.LongPollingTimeoutMs = 10000
+	c.FramesPerPeriod = 10000
+	c.MaxRequestSize = 1000000
+	c.BillingPeriodMs = 3600 * 1000
+	c.Premium.FramesPerPeriod = 1000000000
+	return c
+}
+
+// Tier returns the effective limits for a free or premium address
+func (c Limits) Tier(isPremium bool) TierLimits {
+	override := c.Free
+	if isPremium {
+		override = c.Premium
+	}
+
+	result := c.TierLimits
+	if override.MessageLifetimeMs > 0 {
+		result.MessageLifetimeMs = override.MessageLifetimeMs
+	}
+	if override.AddressIdleTimeoutMs > 0 {
+		result.AddressIdleTimeoutMs = override.AddressIdleTimeoutMs
+	}
+	if override.MaxMessages > 0 {
+		result.MaxMessages = override.MaxMessages
+	}
+	if override.LongPollingTimeoutMs > 0 {
+		result.LongPollingTimeoutMs = override.LongPollingTimeoutMs
+	}
+	if override.FramesPerPeriod > 0 {
+		result.FramesPerPeriod = override.FramesPerPeriod
+	}
+	return result
+}
+
+// MaxMessageLifetime is the longest message lifetime of all tiers
+func (c Limits) MaxMessageLifetime() time.Duration {
+	lifetimeMs := c.Tier(false).MessageLifetimeMs
+	if premiumLifetimeMs := c.Tier(true).MessageLifetimeMs; premiumLifetimeMs > lifetimeMs {
+		lifetimeMs = premiumLifetimeMs
+	}
+	return time.Duration(lifetimeMs) * time.Millisecond
+}
+
+func (c Limits) BillingPeriod() time.Duration {
+	return time.Duration(c.BillingPeriodMs) * time.Millisecond
+}
+
+func (c TierLimits) MessageLifetime() time.Duration {
+	return time.Duration(c.MessageLifetimeMs) * time.Millisecond
+}
+
+func (c TierLimits) AddressIdleTimeout() time.Duration {
+	return time.Duration(c.AddressIdleTimeoutMs) * time.Millisecond
+}
+
+func (c TierLimits) LongPollingTimeout() time.Duration {
+	return time.Duration(c.LongPollingTimeoutMs) * time.Millisecond
+}
@@ -0,0 +1,130 @@
+package xchgr_server
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Idle CPU of many waiting readers: the listener based long polling
+// against the former loop that called GetMessages every 10 ms.
+// The cpu-ms/op metric is the process CPU time spent during the idle window.
+
+const (
+	benchPollers         = 1000
+	benchIdleWindow      = 100 * time.Millisecond
+	benchFormerPollDelay = 10 * time.Millisecond
+)
+
+func newBenchRouter() *Router {
+	config := DefaultConfig()
+	config.UdpListener = ""
+	config.Premium.Provider = PREMIUM_PROVIDER_NONE
+	config.RateLimits.PerAddress.Rate = 0
+	config.Limits.FramesPerPeriod = math.MaxUint32
+	storage, _ := NewStorage(STORAGE_TYPE_MEMORY, "")
+	return NewRouter(config, storage)
+}
+
+// benchReadRequest is the /api/r request for the address with the index i
+func benchReadRequest(i int) []byte {
+	request := make([]byte, 46)
+	binary.LittleEndian.PutUint64(request[8:], 1024*1024)
+	binary.LittleEndian.PutUint32(request[16:], uint32(i+1))
+	return request
+}
+
+func benchFrame(i int) []byte {
+	frame := make([]byte, FRAME_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(frame[0:], FRAME_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(frame[FRAME_DEST_ADDRESS_POS:], uint32(i+1))
+	return frame
+}
+
+func benchIdle(b *testing.B, poller func(router *Router, request []byte, done chan struct{})) {
+	router := newBenchRouter()
+	var cpuTotal time.Duration
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < benchPollers; i++ {
+			wg.Add(1)
+			go func(request []byte) {
+				defer wg.Done()
+				poller(router, request, done)
+			}(benchReadRequest(i))
+		}
+		// Let the pollers reach the waiting state
+		time.Sleep(10 * time.Millisecond)
+		cpuBegin := processCpuTime()
+		time.Sleep(benchIdleWindow)
+		cpuTotal += processCpuTime() - cpuBegin
+		close(done)
+		wg.Wait()
+	}
+	b.ReportMetric(float64(cpuTotal.Milliseconds())/float64(b.N), "cpu-ms/op")
+}
+
+func BenchmarkLongPollingIdleListeners(b *testing.B) {
+	benchIdle(b, func(router *Router, request []byte, done chan struct{}) {
+		addressStorage, listener, err := router.Subscribe(request)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer router.Unsubscribe(addressStorage, listener)
+		for {
+			_, count, _ := router.GetMessages(request)
+			if count > 0 {
+				return
+			}
+			select {
+			case <-listener:
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkLongPollingIdleFormerLoop(b *testing.B) {
+	benchIdle(b, func(router *Router, request []byte, done chan struct{}) {
+		for {
+			_, count, _ := router.GetMessages(request)
+			if count > 0 {
+				return
+			}
+			select {
+			case <-time.After(benchFormerPollDelay):
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+// Time from Put to the wakeup of the waiting reader
+func BenchmarkLongPollingWakeup(b *testing.B) {
+	router := newBenchRouter()
+	request := benchReadRequest(0)
+	frame := benchFrame(0)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		addressStorage, listener, err := router.Subscribe(request)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = router.PutFrames(frame, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		<-listener
+		response, _, _ := router.GetMessages(request)
+		// Next read continues after the received frame
+		copy(request[0:8], response[0:8])
+		router.Unsubscribe(addressStorage, listener)
+	}
+}
@@ -0,0 +1,118 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// Prometheus text exposition format
+
+type metricsWriter struct {
+	buffer bytes.Buffer
+}
+
+func (c *metricsWriter) header(name string, metricType string, help string) {
+	fmt.Fprintf(&c.buffer, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(&c.buffer, "# TYPE %s %s\n", name, metricType)
+}
+
+func (c *metricsWriter) value(name string, value int) {
+	fmt.Fprintf(&c.buffer, "%s %d\n", name, value)
+}
+
+func (c *metricsWriter) labeledValue(name string, labelName string, labelValue string, value int) {
+	fmt.Fprintf(&c.buffer, "%s{%s=%q} %d\n", name, labelName, labelValue, value)
+}
+
+func (c *metricsWriter) counter(name string, help string, value int) {
+	c.header(name, "counter", help)
+	c.value(name, value)
+}
+
+func (c *metricsWriter) gauge(name string, help string, value int) {
+	c.header(name, "gauge", help)
+	c.value(name, value)
+}
+
+func (c *Router) Metrics() []byte {
+	c.mtx.Lock()
+	stat := c.stat
+	// Stop removes the nonces
+	powComplexity := 0
+	if c.nonces != nil {
+		powComplexity = int(c.nonces.Complexity())
+	}
+	addresses := make([]*AddressStorage, 0, len(c.addresses))
+	for _, a := range c.addresses {
+		addresses = append(addresses, a)
+	}
+	c.mtx.Unlock()
+
+	queuedMessages := 0
+	for _, a := range addresses {
+		queuedMessages += a.MessagesCount()
+	}
+
+	var w metricsWriter
+	w.counter("xchgr_frames_in_total", "Frames stored by the router.", stat.FramesIn)
+	w.counter("xchgr_frames_out_total", "Frames delivered to readers.", stat.FramesOut)
+	w.counter("xchgr_bytes_in_total", "Bytes of stored frames.", stat.BytesIn)
+	w.counter("xchgr_bytes_out_total", "Bytes of delivered frames.", stat.BytesOut)
+	w.header("xchgr_frames_rejected_total", "counter", "Frames rejected by reason.")
+	w.labeledValue("xchgr_frames_rejected_total", "reason", "limit", stat.FramesRejectedLimit)
+	w.labeledValue("xchgr_frames_rejected_total", "reason", "signature", stat.FramesRejectedSignature)
+	w.header("xchgr_rate_limited_total", "counter", "Requests rejected by the rate limits.")
+	w.labeledValue("xchgr_rate_limited_total", "limit", "ip", stat.RateLimitedIp)
+	w.labeledValue("xchgr_rate_limited_total", "limit", "address", stat.RateLimitedAddress)
+	w.labeledValue("xchgr_rate_limited_total", "limit", "udp_ip", stat.UdrRateLimitedIp)
+	w.counter("xchgr_frames_forwarded_total", "Frames relayed to other routers.", stat.FramesForwarded)
+	w.counter("xchgr_frames_forward_errors_total", "Frames that could not be relayed to other routers.", stat.FramesForwardErrors)
+	w.counter("xchgr_frames_forward_retries_total", "Retried attempts to relay frames.", stat.FramesForwardRetries)
+	w.counter("xchgr_udr_registered_total", "Accepted UDR registrations.", stat.UdrRegistered)
+	w.counter("xchgr_udr_rejected_total", "Rejected UDR registrations.", stat.UdrRejected)
+	w.counter("xchgr_udr_rendezvous_requests_total", "UDP rendezvous requests.", stat.UdrRendezvousRequests)
+	w.header("xchgr_udr_rendezvous_total", "counter", "UDP rendezvous requests by result.")
+	w.labeledValue("xchgr_udr_rendezvous_total", "result", "completed", stat.UdrRendezvousCompleted)
+	w.labeledValue("xchgr_udr_rendezvous_total", "result", "no_peer", stat.UdrRendezvousNoPeer)
+	w.labeledValue("xchgr_udr_rendezvous_total", "result", "rejected", stat.UdrRendezvousRejected)
+	w.counter("xchgr_udr_frames_received_total", "Datagrams with frames accepted over UDP.", stat.UdrFramesReceived)
+	w.counter("xchgr_udr_frames_rejected_total", "Datagrams with frames rejected over UDP.", stat.UdrFramesRejected)
+	w.counter("xchgr_udr_pushed_total", "Datagrams with frames pushed to UDR endpoints.", stat.UdrPushed)
+	w.counter("xchgr_udr_push_skipped_total", "Frames too large to be pushed over UDP.", stat.UdrPushSkipped)
+	w.counter("xchgr_stun_requests_total", "STUN Binding requests.", stat.StunRequests)
+	w.counter("xchgr_stun_errors_total", "STUN Binding requests answered with an error.", stat.StunErrors)
+	w.counter("xchgr_nat_classified_total", "Completed NAT classifications.", stat.NatClassified)
+	w.counter("xchgr_relay_allocated_total", "Created relay allocations.", stat.RelayAllocated)
+	w.counter("xchgr_relay_datagrams_total", "Datagrams forwarded by the relay.", stat.RelayDatagrams)
+	w.counter("xchgr_relay_bytes_total", "Payload bytes forwarded by the relay.", stat.RelayBytes)
+	w.counter("xchgr_relay_dropped_total", "Relay datagrams dropped by limits or with an unknown channel.", stat.RelayDropped)
+	w.counter("xchgr_pow_accepted_total", "Write requests with an accepted proof of work.", stat.PowAccepted)
+	w.counter("xchgr_pow_rejected_total", "Write requests rejected because of a missing or wrong proof of work.", stat.PowRejected)
+
+	w.header("xchgr_http_requests_total", "counter", "HTTP requests by endpoint.")
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "r", stat.HttpRequestsR)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "w", stat.HttpRequestsW)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "n", stat.HttpRequestsN)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "billing", stat.HttpRequestsB)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "ns", stat.HttpRequestsNS)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "debug", stat.HttpRequestsD)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "stat", stat.HttpRequestsS)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "file", stat.HttpRequestsF)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "ws", stat.HttpRequestsWS)
+	w.labeledValue("xchgr_http_requests_total", "endpoint", "auth", stat.HttpRequestsA)
+
+	w.header("xchgr_premium_updates_total", "counter", "Premium provider updates by result.")
+	w.labeledValue("xchgr_premium_updates_total", "result", "success", c.premium.CounterSuccess())
+	w.labeledValue("xchgr_premium_updates_total", "result", "error", c.premium.CounterError())
+	w.gauge("xchgr_premium_records", "Records loaded by the premium provider.", c.premium.RecordsCount())
+	_, premiumAge := c.premium.Status()
+	w.gauge("xchgr_premium_age_seconds", "Age of the premium status data.", int(premiumAge/time.Second))
+
+	w.gauge("xchgr_addresses", "Addresses with a queue on the router.", len(addresses))
+	w.gauge("xchgr_queued_messages", "Frames waiting in the queues.", queuedMessages)
+	w.gauge("xchgr_relay_allocations", "Relay allocations on the router.", stat.RelayActive)
+	w.gauge("xchgr_udr_subscriptions", "Addresses receiving frames over UDP.", stat.UdrSubscriptions)
+	w.gauge("xchgr_pow_complexity", "Complexity of the issued nonces.", powComplexity)
+	return w.buffer.Bytes()
+}
@@ -0,0 +1,86 @@
+package xchgr_server
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//////////////////////////////////////////////////////
+// Name registration (/api/ns/register)
+// Request (JSON):
+// {"claim": base64, "public_key": base64, "signature": base64}
+// claim      - JSON: {"op": "...", "name": "...", "to": "#...", "nonce": base64}
+//              op: register, renew, transfer (to another address) or release
+//              nonce: issued by /api/nonce
+// public key - PKCS #1 DER of the RSA key of the owner address,
+//              the address is SHA256(public key)[:30]
+// signature  - RSA PKCS #1 v1.5 over SHA256(claim)
+//////////////////////////////////////////////////////
+
+const (
+	NAME_OP_REGISTER = "register"
+	NAME_OP_RENEW    = "renew"
+	NAME_OP_TRANSFER = "transfer"
+	NAME_OP_RELEASE  = "release"
+)
+
+var (
+	ErrNameClaimMalformed = errors.New("malformed name claim")
+	ErrNameClaimSignature = errors.New("wrong name claim signature")
+)
+
+type NameClaimRequest struct {
+	Claim     []byte `json:"claim"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+type NameClaim struct {
+	Op    string `json:"op"`
+	Name  string `json:"name"`
+	To    string `json:"to"`
+	Nonce []byte `json:"nonce"`
+}
+
+// VerifyNameClaim checks the signature and the nonce of the claim and returns the claim and the address of the owner
+func (c *Router) VerifyNameClaim(requestBS []byte) (claim NameClaim, owner string, err error) {
+	var request NameClaimRequest
+	err = json.Unmarshal(requestBS, &request)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrNameClaimMalformed, err)
+		return
+	}
+	err = json.Unmarshal(request.Claim, &claim)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrNameClaimMalformed, err)
+		return
+	}
+	switch claim.Op {
+	case NAME_OP_REGISTER, NAME_OP_RENEW, NAME_OP_RELEASE:
+	case NAME_OP_TRANSFER:
+		to, errTo := base32.StdEncoding.DecodeString(strings.ToUpper(strings.TrimPrefix(claim.To, "#")))
+		if errTo != nil || len(to) != AddressBytesSize {
+			err = fmt.Errorf("%w: wrong target address", ErrNameClaimMalformed)
+			return
+		}
+	default:
+		err = fmt.Errorf("%w: unknown op %q", ErrNameClaimMalformed, claim.Op)
+		return
+	}
+
+	err = verifySignature(request.PublicKey, request.Claim, request.Signature)
+	if err != nil {
+		err = ErrNameClaimSignature
+		return
+	}
+	// The nonce is checked last: a successful check consumes it
+	if !c.nonces.Check(claim.Nonce) {
+		err = fmt.Errorf("%w: wrong nonce", ErrNameClaimSignature)
+		return
+	}
+	owner = addressKey(addressOfPublicKey(request.PublicKey))
+	return
+}
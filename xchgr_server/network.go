@@ -13,13 +13,20 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	NETWORK_LOAD_TIMEOUT = 10 * time.Second
 )
 
 type Network struct {
 	mtx sync.Mutex
 
-	fromInternet       bool
-	fromInternetLoaded bool
+	fromInternet        bool
+	fromInternetLoaded  bool
+	fromInternetLoading bool
+	url                 string
 
 	Name     string  `json:"name"`
 	Ranges   []*rng  `json:"ranges"`
@@ -65,9 +72,10 @@ func NewNetwork() *Network {
 	return &c
 }
 
-func NewNetworkFromInternet() *Network {
+func NewNetworkFromInternet(url string) *Network {
 	var c Network
 	c.init()
+	c.url = url
 	c.fromInternet = true
 	c.fromInternetLoaded = false
 	go c.loadNetworkFromInternet()
@@ -130,6 +138,17 @@ func NewNetworkDefault() *Network {
 	return network
 }
 
+// NewNetworkByConfig creates the network map from the configured source
+func NewNetworkByConfig(config Config) *Network {
+	switch config.Network.Source {
+	case NETWORK_SOURCE_FILE:
+		return NewNetworkFromFileOrCreate(config.NetworkFilePath())
+	case NETWORK_SOURCE_INTERNET:
+		return NewNetworkFromInternet(config.Network.Url)
+	}
+	return NewNetworkDefault()
+}
+
 func (c *Network) ReloadFromInternet() {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
@@ -137,25 +156,30 @@ func (c *Network) ReloadFromInternet() {
 	c.fromInternetLoaded = false
 }
 
-func (c *Network) loadNetworkFromInternet() {
+// Loaded reports whether the network map is available
+func (c *Network) Loaded() bool {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
+	return !c.fromInternet || c.fromInternetLoaded
+}
 
-	if !c.fromInternet {
+// loadNetworkFromInternet downloads the network map once.
+// The lock is not held during the request, the callers use the current map meanwhile.
+func (c *Network) loadNetworkFromInternet() {
+	c.mtx.Lock()
+	if !c.fromInternet || c.fromInternetLoaded || c.fromInternetLoading {
+		c.mtx.Unlock()
 		return
 	}
+	c.fromInternetLoading = true
+	url := c.url
+	c.mtx.Unlock()
 
-	if c.fromInternetLoaded {
-		return
-	}
+	networkBS, err := c.download(url)
 
-	resp, err := http.Get("https://xchgx.net/network.json")
-	if err != nil {
-		return
-	}
-	var networkBS []byte
-	networkBS, err = io.ReadAll(resp.Body)
-	resp.Body.Close()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.fromInternetLoading = false
 	if err != nil {
 		return
 	}
@@ -165,6 +189,19 @@ func (c *Network) loadNetworkFromInternet() {
 	}
 }
 
+func (c *Network) download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: NETWORK_LOAD_TIMEOUT}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("network map download status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
 func (c *Network) SaveToFile(fileName string) error {
 	bs := c.toBytes()
 	err := ioutil.WriteFile(fileName, bs, 0666)
@@ -221,6 +258,40 @@ func (c *Network) GetNodesAddressesByAddress(address string) []string {
 		return make([]string, 0)
 	}
 
+	preferredRange := c.getRange(addressBS)
+
+	addresses := make([]string, 0)
+	if preferredRange != nil {
+		for _, host := range preferredRange.Hosts {
+			addresses = append(addresses, host.Address)
+		}
+	}
+
+	// Randomize
+	rnd := make([]byte, len(addresses))
+	rand.Read(rnd)
+	sort.Slice(addresses, func(i, j int) bool {
+		return rnd[i] < rnd[j]
+	})
+
+	return addresses
+}
+
+// GetRangePrefixByAddress returns the prefix of the range that owns the address.
+// It does not load the network from the Internet.
+func (c *Network) GetRangePrefixByAddress(addressBS []byte) (prefix string, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	r := c.getRange(addressBS)
+	if r == nil {
+		return "", false
+	}
+	return r.Prefix, true
+}
+
+// getRange returns the longest range matching the address
+func (c *Network) getRange(addressBS []byte) *rng {
 	SHAPublicKeyHex := hex.EncodeToString(addressBS)
 	SHAPublicKeyHex = strings.ToLower(SHAPublicKeyHex)
 
@@ -239,39 +310,78 @@ func (c *Network) GetNodesAddressesByAddress(address string) []string {
 			preferredRangeScore = rangeScore
 		}
 	}
+	return preferredRange
+}
 
-	addresses := make([]string, 0)
-	if preferredRange != nil {
-		for _, host := range preferredRange.Hosts {
-			addresses = append(addresses, host.Address)
+// IsNetworkHost reports whether the IP belongs to one of the hosts of the network map
+func (c *Network) IsNetworkHost(ip string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	isHost := func(h *host) bool {
+		return hostIP(h.Address) == ip
+	}
+
+	for _, r := range c.Ranges {
+		for _, h := range r.Hosts {
+			if isHost(h) {
+				return true
+			}
+		}
+	}
+	for _, h := range c.Gateways {
+		if isHost(h) {
+			return true
 		}
 	}
+	return false
+}
 
-	// Randomize
-	rnd := make([]byte, len(addresses))
-	rand.Read(rnd)
-	sort.Slice(addresses, func(i, j int) bool {
-		return rnd[i] < rnd[j]
-	})
+// GetLocalPrefixes returns the ranges served by this host: the hosts equal to selfHost
+// or, if selfHost is empty, the hosts with an IP of a local interface
+func (c *Network) GetLocalPrefixes(selfHost string) []string {
+	c.loadNetworkFromInternet()
 
-	return addresses
-}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var localIPs []string
+	if selfHost == "" {
+		localIPs = c.GetLocalIPs()
+	}
+	isLocal := func(h *host) bool {
+		if selfHost != "" {
+			return h.Address == selfHost || hostIP(h.Address) == selfHost
+		}
+		for _, ip := range localIPs {
+			if hostIP(h.Address) == ip {
+				return true
+			}
+		}
+		return false
+	}
 
-func (c *Network) GetLocalPrefixes() []string {
 	prefixes := make([]string, 0)
-	localIPs := c.GetLocalIPs()
 	for _, r := range c.Ranges {
 		for _, h := range r.Hosts {
-			for _, ip := range localIPs {
-				if strings.Contains(h.Address, ip) {
-					prefixes = append(prefixes, r.Prefix)
-				}
+			if isLocal(h) {
+				prefixes = append(prefixes, r.Prefix)
+				break
 			}
 		}
 	}
 	return prefixes
 }
 
+// hostIP returns the host part of host:port
+func hostIP(address string) string {
+	ip, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return ip
+}
+
 func (c *Network) GetLocalIPs() (result []string) {
 	result = make([]string, 0)
 	ifaces, err := net.Interfaces()
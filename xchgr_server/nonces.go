@@ -49,6 +49,20 @@ func (c *Nonces) fillNonce(index int) {
 	}
 }
 
+// SetComplexity sets the complexity for the nonces issued from now on
+func (c *Nonces) SetComplexity(complexity byte) {
+	c.mtx.Lock()
+	c.complexity = complexity
+	c.mtx.Unlock()
+}
+
+func (c *Nonces) Complexity() (complexity byte) {
+	c.mtx.Lock()
+	complexity = c.complexity
+	c.mtx.Unlock()
+	return
+}
+
 func (c *Nonces) Next() [NONCE_SIZE]byte {
 	var result [NONCE_SIZE]byte
 	c.mtx.Lock()
@@ -0,0 +1,88 @@
+package xchgr_server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+	"strings"
+)
+
+//////////////////////////////////////////////////////
+// Proof of work
+// PoW: [nonce 16 bytes][salt up to POW_MAX_SALT_SIZE bytes]
+// The nonce is issued by /api/nonce and can be used once.
+// SHA256(PoW) must have at least nonce[NONCE_COMPLEXITY_POS] + powExtraBits(n)
+// leading zero bits (see CheckHash), where n is the number of frames of the
+// batch that need the PoW: one more bit for every doubling of n,
+// so the work grows with the size of the batch.
+//////////////////////////////////////////////////////
+
+const (
+	POW_MAX_SALT_SIZE = 64
+)
+
+var (
+	ErrPowRequired = errors.New("proof of work required")
+	ErrPowInvalid  = errors.New("wrong proof of work")
+)
+
+func (c *Router) NextNonce() [NONCE_SIZE]byte {
+	return c.nonces.Next()
+}
+
+// checkPow is required for a batch if PoW is enabled and any frame is not signed
+// by an address with premium status (the source of an unsigned frame is not proved)
+func (c *Router) checkPow(frames []*Frame, pow []byte) error {
+	if !c.powConfig.Enabled {
+		return nil
+	}
+
+	required := 0
+	for _, frame := range frames {
+		if !frame.verified || !c.premium.IsPremium(strings.Trim(frame.SrcAddressString(), "#")) {
+			required++
+		}
+	}
+	if required == 0 {
+		return nil
+	}
+
+	err := c.verifyPow(pow, powExtraBits(required))
+
+	c.mtx.Lock()
+	if err != nil {
+		c.stat.PowRejected++
+	} else {
+		c.stat.PowAccepted++
+	}
+	c.mtx.Unlock()
+
+	return err
+}
+
+// powExtraBits is ceil(log2(frames))
+func powExtraBits(frames int) int {
+	return bits.Len(uint(frames - 1))
+}
+
+func (c *Router) verifyPow(pow []byte, extraBits int) error {
+	if len(pow) == 0 {
+		return ErrPowRequired
+	}
+	if len(pow) < NONCE_SIZE || len(pow) > NONCE_SIZE+POW_MAX_SALT_SIZE {
+		return ErrPowInvalid
+	}
+	complexity := int(pow[NONCE_COMPLEXITY_POS]) + extraBits
+	if complexity > 255 {
+		return ErrPowInvalid
+	}
+	hash := sha256.Sum256(pow)
+	if !CheckHash(hash[:], byte(complexity)) {
+		return ErrPowInvalid
+	}
+	// The nonce is checked last: a successful check consumes it
+	if !c.nonces.Check(pow[:NONCE_SIZE]) {
+		return ErrPowInvalid
+	}
+	return nil
+}
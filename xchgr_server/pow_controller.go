@@ -0,0 +1,102 @@
+package xchgr_server
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	POW_HISTORY_SIZE = 60
+)
+
+// PowLoad is the load measured by the router for the last statistics interval
+type PowLoad struct {
+	WritesPerSecond int   `json:"writes_per_second"`
+	QueuedBytes     int64 `json:"queued_bytes"`
+	CpuPercent      int   `json:"cpu_percent"`
+}
+
+type PowHistoryItem struct {
+	DT         time.Time `json:"dt"`
+	Complexity int       `json:"complexity"`
+	PowLoad
+}
+
+// PowController raises the complexity by one bit every adjust period
+// while any load value is above its high threshold
+// and lowers it by one bit while all of them are below the half of the thresholds.
+// The complexity is kept in [pow.complexity, pow.max_complexity].
+type PowController struct {
+	mtx          sync.Mutex
+	config       PowConfig
+	complexity   int
+	lastAdjustDT time.Time
+	history      []PowHistoryItem
+
+	lastCpuTime time.Duration
+	lastCpuDT   time.Time
+}
+
+func NewPowController(config PowConfig) *PowController {
+	var c PowController
+	c.config = config
+	c.complexity = config.Complexity
+	c.history = make([]PowHistoryItem, 0, POW_HISTORY_SIZE)
+	return &c
+}
+
+func (c *PowController) Enabled() bool {
+	return c.config.Enabled && c.config.Adaptive
+}
+
+// CpuPercent returns the CPU usage of the process since the previous call (100% = all cores)
+func (c *PowController) CpuPercent(now time.Time) int {
+	cpuTime := processCpuTime()
+	result := 0
+	if !c.lastCpuDT.IsZero() && now.After(c.lastCpuDT) {
+		wall := now.Sub(c.lastCpuDT) * time.Duration(runtime.NumCPU())
+		result = int(100 * (cpuTime - c.lastCpuTime) / wall)
+	}
+	c.lastCpuTime = cpuTime
+	c.lastCpuDT = now
+	return result
+}
+
+// Update adjusts the complexity for the load and returns the new complexity
+func (c *PowController) Update(now time.Time, load PowLoad) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if now.Sub(c.lastAdjustDT) >= time.Duration(c.config.AdjustPeriodMs)*time.Millisecond {
+		c.lastAdjustDT = now
+		high := load.WritesPerSecond > c.config.HighWritesPerSecond ||
+			load.QueuedBytes > c.config.HighQueuedBytes ||
+			load.CpuPercent > c.config.HighCpuPercent
+		low := load.WritesPerSecond < c.config.HighWritesPerSecond/2 &&
+			load.QueuedBytes < c.config.HighQueuedBytes/2 &&
+			load.CpuPercent < c.config.HighCpuPercent/2
+
+		if high && c.complexity < c.config.MaxComplexity {
+			c.complexity++
+		}
+		if low && c.complexity > c.config.Complexity {
+			c.complexity--
+		}
+	}
+
+	if len(c.history) == POW_HISTORY_SIZE {
+		copy(c.history, c.history[1:])
+		c.history = c.history[:POW_HISTORY_SIZE-1]
+	}
+	c.history = append(c.history, PowHistoryItem{DT: now, Complexity: c.complexity, PowLoad: load})
+	return c.complexity
+}
+
+func (c *PowController) History() []PowHistoryItem {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	result := make([]PowHistoryItem, len(c.history))
+	copy(result, c.history)
+	return result
+}
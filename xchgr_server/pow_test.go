@@ -0,0 +1,99 @@
+package xchgr_server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// solvePow returns a PoW of the nonce with exactly zeroBits leading zero bits,
+// so it is valid for zeroBits and not for more
+func solvePow(nonce [NONCE_SIZE]byte, zeroBits int) []byte {
+	pow := make([]byte, NONCE_SIZE+8)
+	copy(pow, nonce[:])
+	for salt := uint64(0); ; salt++ {
+		binary.LittleEndian.PutUint64(pow[NONCE_SIZE:], salt)
+		hash := sha256.Sum256(pow)
+		if CheckHash(hash[:], byte(zeroBits)) && !CheckHash(hash[:], byte(zeroBits+1)) {
+			return pow
+		}
+	}
+}
+
+func TestPowExtraBits(t *testing.T) {
+	for frames, extraBits := range map[int]int{1: 0, 2: 1, 3: 2, 4: 2, 5: 3, 8: 3, 9: 4, 1000: 10} {
+		if n := powExtraBits(frames); n != extraBits {
+			t.Errorf("%d frames: %d extra bits, expected %d", frames, n, extraBits)
+		}
+	}
+}
+
+func TestPowGrowsWithBatch(t *testing.T) {
+	router := newBenchRouter()
+	router.powConfig.Enabled = true
+	router.nonces.SetComplexity(3)
+
+	var batch []byte
+	for i := 0; i < 4; i++ {
+		batch = append(batch, benchFrame(i)...)
+	}
+	if err := router.PutFrames(batch, nil); !errors.Is(err, ErrPowRequired) {
+		t.Errorf("batch without PoW: %v", err)
+	}
+
+	// A single frame needs the complexity of the nonce
+	if err := router.PutFrames(benchFrame(0), solvePow(router.NextNonce(), 3)); err != nil {
+		t.Errorf("single frame: %v", err)
+	}
+
+	// 4 frames need 2 more bits, a failed check does not consume the nonce
+	nonce := router.NextNonce()
+	if err := router.PutFrames(batch, solvePow(nonce, 4)); !errors.Is(err, ErrPowInvalid) {
+		t.Errorf("batch with the PoW of a smaller batch: %v", err)
+	}
+	pow := solvePow(nonce, 5)
+	if err := router.PutFrames(batch, pow); err != nil {
+		t.Errorf("batch: %v", err)
+	}
+	// The nonce can be used once
+	if err := router.PutFrames(batch, pow); !errors.Is(err, ErrPowInvalid) {
+		t.Errorf("reused nonce: %v", err)
+	}
+	router.mtx.Lock()
+	accepted, rejected := router.stat.PowAccepted, router.stat.PowRejected
+	router.mtx.Unlock()
+	if accepted != 2 || rejected != 3 {
+		t.Errorf("%d accepted, %d rejected", accepted, rejected)
+	}
+}
+
+func TestNonces(t *testing.T) {
+	nonces := NewNonces(2)
+	nonces.SetComplexity(7)
+	first := nonces.Next()
+	if first[NONCE_COMPLEXITY_POS] != 7 {
+		t.Errorf("complexity %d", first[NONCE_COMPLEXITY_POS])
+	}
+	forged := first
+	forged[NONCE_SIZE-1] ^= 0xFF
+	if nonces.Check(forged[:]) {
+		t.Error("a changed nonce is accepted")
+	}
+	if !nonces.Check(first[:]) || nonces.Check(first[:]) {
+		t.Error("the nonce must be accepted once")
+	}
+
+	// The ring overwrites the oldest nonce
+	second := nonces.Next()
+	_ = nonces.Next()
+	_ = nonces.Next()
+	if nonces.Check(second[:]) {
+		t.Error("an overwritten nonce is accepted")
+	}
+	outOfRange := nonces.Next()
+	binary.LittleEndian.PutUint32(outOfRange[:], 2)
+	if nonces.Check(outOfRange[:]) || nonces.Check(outOfRange[:NONCE_SIZE-1]) {
+		t.Error("a nonce out of the ring is accepted")
+	}
+}
@@ -0,0 +1,24 @@
+package xchgr_server
+
+import (
+	"time"
+
+	"github.com/ipoluianov/xchgr/blockchain/premium_client"
+)
+
+const (
+	PREMIUM_PROVIDER_CONTRACT01 = "contract01"
+	PREMIUM_PROVIDER_FILE       = "file"
+	PREMIUM_PROVIDER_NONE       = "none"
+)
+
+// NewPremiumProvider creates the provider of premium status selected in the config
+func NewPremiumProvider(config Config) premium_client.PremiumProvider {
+	switch config.Premium.Provider {
+	case PREMIUM_PROVIDER_FILE:
+		return premium_client.NewFileProvider(config.PremiumFilePath(), time.Duration(config.Premium.ReloadPeriodMs)*time.Millisecond)
+	case PREMIUM_PROVIDER_NONE:
+		return premium_client.NewNoneProvider()
+	}
+	return NewContract01(config.Contract01, config.Contract01Path())
+}
@@ -0,0 +1,88 @@
+package xchgr_server
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipoluianov/xchgr/blockchain/premium_client"
+)
+
+func newPremiumTestRouter(premium premium_client.PremiumProvider) *Router {
+	config := DefaultConfig()
+	config.UdpListener = ""
+	config.Premium.Provider = PREMIUM_PROVIDER_NONE
+	config.Limits.FramesPerPeriod = 1
+	config.Limits.Premium.FramesPerPeriod = 3
+	storage, _ := NewStorage(STORAGE_TYPE_MEMORY, "")
+	router := NewRouter(config, storage)
+	router.premium = premium
+	return router
+}
+
+func premiumTestFrame(src byte, dest byte) []byte {
+	frame := make([]byte, FRAME_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(frame[0:], FRAME_HEADER_SIZE)
+	frame[FRAME_SRC_ADDRESS_POS] = src
+	frame[FRAME_DEST_ADDRESS_POS] = dest
+	return frame
+}
+
+func premiumTestAddress(b byte) string {
+	addressBS := make([]byte, AddressBytesSize)
+	addressBS[0] = b
+	return addressKey(addressBS)
+}
+
+func putFramesUntilLimit(t *testing.T, router *Router, src byte) int {
+	for n := 0; n < 10; n++ {
+		// Every frame goes to another destination: the sender is charged
+		err := router.PutFrames(premiumTestFrame(src, byte(n+1)), nil)
+		if errors.Is(err, ErrLimitExceeded) {
+			return n
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return -1
+}
+
+func TestPremiumSenderLimits(t *testing.T) {
+	premium := premium_client.NewMockProvider()
+	router := newPremiumTestRouter(premium)
+	premium.Set(strings.Trim(premiumTestAddress(0xA0), "#"), time.Time{})
+
+	if n := putFramesUntilLimit(t, router, 0xA0); n != 3 {
+		t.Errorf("premium sender: %d frames accepted, expected 3", n)
+	}
+	if n := putFramesUntilLimit(t, router, 0xB0); n != 1 {
+		t.Errorf("free sender: %d frames accepted, expected 1", n)
+	}
+
+	bi, _ := router.GetBillingInfo(premiumTestAddress(0xA0))
+	if bi.Counter != 3 || bi.Limit != 3 {
+		t.Errorf("premium billing info: %+v", bi)
+	}
+
+	// The counter of the period is kept, the limit follows the status
+	premium.Remove(strings.Trim(premiumTestAddress(0xA0), "#"))
+	bi, _ = router.GetBillingInfo(premiumTestAddress(0xA0))
+	if bi.Counter != 3 || bi.Limit != 1 {
+		t.Errorf("billing info after the premium status is removed: %+v", bi)
+	}
+}
+
+func TestNoneProvider(t *testing.T) {
+	config := DefaultConfig()
+	config.Premium.Provider = PREMIUM_PROVIDER_NONE
+	premium := NewPremiumProvider(config)
+	if _, ok := premium.(*premium_client.NoneProvider); !ok {
+		t.Fatalf("provider %T, expected *premium_client.NoneProvider", premium)
+	}
+	if premium.IsPremium(strings.Trim(premiumTestAddress(0xA0), "#")) {
+		t.Error("none provider gives premium status")
+	}
+}
@@ -0,0 +1,163 @@
+package xchgr_server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig is a token bucket: Rate tokens per second, up to Burst tokens.
+// Zero Rate disables the limit.
+type RateLimitConfig struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type RateLimitsConfig struct {
+	// Requests of a client IP to any endpoint
+	PerIp RateLimitConfig `json:"per_ip"`
+	// Frames from an address and reads of an address
+	PerAddress RateLimitConfig `json:"per_address"`
+	// Reverse proxies: for their requests the client IP is taken from X-Forwarded-For
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// ErrOverBurst is returned for a request that takes more tokens than the burst:
+// it is never allowed, retrying does not help
+var ErrOverBurst = errors.New("request exceeds the rate limit burst")
+
+// RateLimitError is returned when the limit is exceeded. RetryAfter is the time until the next token.
+type RateLimitError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (c *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s, retry after %v", c.Key, c.RetryAfter)
+}
+
+// AllowIp checks the rate limit of a client IP. Routers of the network are not limited.
+func (c *Router) AllowIp(ip string) error {
+	if !c.ipLimiter.Enabled() || c.network.IsNetworkHost(ip) {
+		return nil
+	}
+	err := c.ipLimiter.Allow(ip, 1, time.Now())
+	if err != nil {
+		c.mtx.Lock()
+		c.stat.RateLimitedIp++
+		c.mtx.Unlock()
+	}
+	return err
+}
+
+func (c *Router) allowAddress(address string, n int) error {
+	err := c.addressLimiter.Allow(address, n, time.Now())
+	if err != nil {
+		c.mtx.Lock()
+		c.stat.RateLimitedAddress++
+		c.mtx.Unlock()
+	}
+	return err
+}
+
+// allowFrames charges every frame to its source address.
+// The batch takes no tokens if any of the addresses is over the limit.
+func (c *Router) allowFrames(frames []*Frame) error {
+	if !c.addressLimiter.Enabled() {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, frame := range frames {
+		if !isZeroAddress(frame.SrcAddress) {
+			counts[frame.SrcAddressString()]++
+		}
+	}
+	err := c.addressLimiter.AllowAll(counts, time.Now())
+	if err != nil {
+		c.mtx.Lock()
+		c.stat.RateLimitedAddress++
+		c.mtx.Unlock()
+	}
+	return err
+}
+
+type tokenBucket struct {
+	tokens float64
+	lastDT time.Time
+}
+
+type RateLimiter struct {
+	mtx     sync.Mutex
+	config  RateLimitConfig
+	buckets map[string]*tokenBucket
+}
+
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	var c RateLimiter
+	c.config = config
+	c.buckets = make(map[string]*tokenBucket)
+	return &c
+}
+
+func (c *RateLimiter) Enabled() bool {
+	return c.config.Rate > 0
+}
+
+// Allow takes n tokens from the bucket of the key
+func (c *RateLimiter) Allow(key string, n int, now time.Time) error {
+	return c.AllowAll(map[string]int{key: n}, now)
+}
+
+// AllowAll takes counts[key] tokens from the bucket of every key
+// if all of the buckets have enough tokens, otherwise it takes nothing
+func (c *RateLimiter) AllowAll(counts map[string]int, now time.Time) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for key, n := range counts {
+		if n > c.config.Burst {
+			return fmt.Errorf("%w: %d for %s, burst %d", ErrOverBurst, n, key, c.config.Burst)
+		}
+		b := c.bucket(key, now)
+		if b.tokens < float64(n) {
+			missing := float64(n) - b.tokens
+			return &RateLimitError{Key: key, RetryAfter: time.Duration(missing / c.config.Rate * float64(time.Second))}
+		}
+	}
+	for key, n := range counts {
+		c.buckets[key].tokens -= float64(n)
+	}
+	return nil
+}
+
+// bucket returns the refilled bucket of the key. c.mtx must be held.
+func (c *RateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(c.config.Burst), lastDT: now}
+		c.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(c.config.Burst), b.tokens+now.Sub(b.lastDT).Seconds()*c.config.Rate)
+	b.lastDT = now
+	return b
+}
+
+// Clear removes the buckets that are full again
+func (c *RateLimiter) Clear(now time.Time) {
+	if !c.Enabled() {
+		return
+	}
+	c.mtx.Lock()
+	for key, b := range c.buckets {
+		if b.tokens+now.Sub(b.lastDT).Seconds()*c.config.Rate >= float64(c.config.Burst) {
+			delete(c.buckets, key)
+		}
+	}
+	c.mtx.Unlock()
+}
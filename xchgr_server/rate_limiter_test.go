@@ -0,0 +1,30 @@
+package xchgr_server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllowAll(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Rate: 1, Burst: 3})
+	now := time.Now()
+
+	err := limiter.AllowAll(map[string]int{"a": 4}, now)
+	if !errors.Is(err, ErrOverBurst) {
+		t.Errorf("over the burst: %v", err)
+	}
+
+	if err = limiter.AllowAll(map[string]int{"a": 2}, now); err != nil {
+		t.Fatal(err)
+	}
+	// "a" has one token left: the batch takes nothing from "b"
+	err = limiter.AllowAll(map[string]int{"a": 2, "b": 3}, now)
+	var rateLimitError *RateLimitError
+	if !errors.As(err, &rateLimitError) || rateLimitError.Key != "a" || rateLimitError.RetryAfter != time.Second {
+		t.Errorf("over the limit: %v", err)
+	}
+	if err = limiter.AllowAll(map[string]int{"b": 3}, now); err != nil {
+		t.Errorf("tokens of b are taken by a rejected batch: %v", err)
+	}
+}
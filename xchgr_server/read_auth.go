@@ -0,0 +1,159 @@
+package xchgr_server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////
+// Read authorization (/api/auth)
+// Request: [nonce 16][flags 1][uint16 pubLen][public key][signature]
+// nonce      - issued by /api/nonce
+// public key - PKCS #1 DER of the address RSA key,
+//              the address is SHA256(public key)[:30]
+// signature  - RSA PKCS #1 v1.5 over SHA256(nonce)
+// flags & READ_AUTH_FLAG_LOCK - from now on the address can be read only with a token
+// Response: [token 16]
+// The token is appended to the /api/r request: [afterId][maxSize][address][token]
+//////////////////////////////////////////////////////
+
+const (
+	READ_TOKEN_SIZE      = 16
+	READ_AUTH_FLAG_LOCK  = byte(0x01)
+	READ_REQUEST_SIZE    = 46
+	READ_AUTH_HEADER_LEN = NONCE_SIZE + 1 + 2
+
+	READ_AUTH_OFF      = "off"
+	READ_AUTH_OPTIONAL = "optional"
+	READ_AUTH_REQUIRED = "required"
+)
+
+var (
+	ErrReadAuthFailed    = errors.New("read authorization failed")
+	ErrReadTokenRequired = errors.New("read token required")
+	ErrReadTokenInvalid  = errors.New("wrong or expired read token")
+)
+
+type ReadAuthConfig struct {
+	// off, optional (only locked addresses need a token) or required
+	Mode            string `json:"mode"`
+	TokenLifetimeMs int    `json:"token_lifetime_ms"`
+	// A lock is kept while the address is read with tokens
+	LockLifetimeMs int `json:"lock_lifetime_ms"`
+}
+
+type readToken struct {
+	address string
+	expires time.Time
+}
+
+type ReadAuth struct {
+	mtx    sync.Mutex
+	config ReadAuthConfig
+	tokens map[[READ_TOKEN_SIZE]byte]readToken
+	locks  map[string]time.Time
+}
+
+func NewReadAuth(config ReadAuthConfig) *ReadAuth {
+	var c ReadAuth
+	c.config = config
+	c.tokens = make(map[[READ_TOKEN_SIZE]byte]readToken)
+	c.locks = make(map[string]time.Time)
+	return &c
+}
+
+// Authorize checks the signed nonce and issues a read token for the address of the key
+func (c *Router) Authorize(request []byte) (token []byte, err error) {
+	if c.readAuth.config.Mode == READ_AUTH_OFF {
+		return nil, errors.New("read authorization is disabled")
+	}
+	if len(request) < READ_AUTH_HEADER_LEN {
+		return nil, ErrReadAuthFailed
+	}
+	nonce := request[:NONCE_SIZE]
+	flags := request[NONCE_SIZE]
+	pubLen := int(binary.LittleEndian.Uint16(request[NONCE_SIZE+1:]))
+	if len(request) < READ_AUTH_HEADER_LEN+pubLen {
+		return nil, ErrReadAuthFailed
+	}
+	publicKeyDer := request[READ_AUTH_HEADER_LEN : READ_AUTH_HEADER_LEN+pubLen]
+	signature := request[READ_AUTH_HEADER_LEN+pubLen:]
+
+	err = verifySignature(publicKeyDer, nonce, signature)
+	if err != nil {
+		return nil, ErrReadAuthFailed
+	}
+	// The nonce is checked last: a successful check consumes it
+	if !c.nonces.Check(nonce) {
+		return nil, ErrReadAuthFailed
+	}
+
+	address := addressKey(addressOfPublicKey(publicKeyDer))
+	return c.readAuth.issue(address, flags&READ_AUTH_FLAG_LOCK != 0, time.Now()), nil
+}
+
+func (c *ReadAuth) issue(address string, lock bool, now time.Time) []byte {
+	var token [READ_TOKEN_SIZE]byte
+	_, _ = rand.Read(token[:])
+
+	c.mtx.Lock()
+	c.tokens[token] = readToken{address: address, expires: now.Add(time.Duration(c.config.TokenLifetimeMs) * time.Millisecond)}
+	if lock {
+		c.locks[address] = now.Add(time.Duration(c.config.LockLifetimeMs) * time.Millisecond)
+	}
+	c.mtx.Unlock()
+	return token[:]
+}
+
+// Check verifies the token of the read request for the address
+func (c *ReadAuth) Check(address string, request []byte, now time.Time) error {
+	if c.config.Mode == READ_AUTH_OFF {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	_, locked := c.locks[address]
+	required := locked || c.config.Mode == READ_AUTH_REQUIRED
+
+	if len(request) < READ_REQUEST_SIZE+READ_TOKEN_SIZE {
+		if required {
+			return ErrReadTokenRequired
+		}
+		return nil
+	}
+
+	var token [READ_TOKEN_SIZE]byte
+	copy(token[:], request[READ_REQUEST_SIZE:])
+	t, ok := c.tokens[token]
+	if !ok || t.address != address || now.After(t.expires) {
+		if required {
+			return ErrReadTokenInvalid
+		}
+		return nil
+	}
+	if locked {
+		c.locks[address] = now.Add(time.Duration(c.config.LockLifetimeMs) * time.Millisecond)
+	}
+	return nil
+}
+
+// Clear removes expired tokens and locks
+func (c *ReadAuth) Clear(now time.Time) {
+	c.mtx.Lock()
+	for token, t := range c.tokens {
+		if now.After(t.expires) {
+			delete(c.tokens, token)
+		}
+	}
+	for address, expires := range c.locks {
+		if now.After(expires) {
+			delete(c.locks, address)
+		}
+	}
+	c.mtx.Unlock()
+}
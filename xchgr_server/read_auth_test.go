@@ -0,0 +1,130 @@
+package xchgr_server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newReadAuthTestRouter(mode string) *Router {
+	router := newBenchRouter()
+	config := DefaultConfig().ReadAuth
+	config.Mode = mode
+	router.readAuth = NewReadAuth(config)
+	return router
+}
+
+// authRequest is the /api/auth request with a new nonce of the router
+func authRequest(t *testing.T, router *Router, key *rsa.PrivateKey, flags byte) []byte {
+	nonce := router.NextNonce()
+	hash := sha256.Sum256(nonce[:])
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	request := append(nonce[:], flags, 0, 0)
+	binary.LittleEndian.PutUint16(request[NONCE_SIZE+1:], uint16(len(pub)))
+	request = append(request, pub...)
+	return append(request, signature...)
+}
+
+// ownReadRequest is the /api/r request for the address of the key with the token
+func ownReadRequest(key *rsa.PrivateKey, token []byte) []byte {
+	request := make([]byte, READ_REQUEST_SIZE)
+	binary.LittleEndian.PutUint64(request[8:], 1024*1024)
+	copy(request[16:], addressOfPublicKey(x509.MarshalPKCS1PublicKey(&key.PublicKey)))
+	return append(request, token...)
+}
+
+func TestReadAuthModes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := newReadAuthTestRouter(READ_AUTH_OFF)
+	if _, err := router.Authorize(authRequest(t, router, key, 0)); err == nil {
+		t.Error("token issued with read authorization off")
+	}
+	if _, _, err := router.GetMessages(ownReadRequest(key, nil)); err != nil {
+		t.Errorf("read with authorization off: %v", err)
+	}
+
+	router = newReadAuthTestRouter(READ_AUTH_REQUIRED)
+	if _, _, err := router.GetMessages(ownReadRequest(key, nil)); !errors.Is(err, ErrReadTokenRequired) {
+		t.Errorf("read without a token: %v", err)
+	}
+	if _, _, err := router.GetMessages(ownReadRequest(key, make([]byte, READ_TOKEN_SIZE))); !errors.Is(err, ErrReadTokenInvalid) {
+		t.Errorf("read with a wrong token: %v", err)
+	}
+	token, err := router.Authorize(authRequest(t, router, key, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := router.GetMessages(ownReadRequest(key, token)); err != nil {
+		t.Errorf("read with the token: %v", err)
+	}
+	// The token is bound to the address of the key
+	request := benchReadRequest(1)
+	if _, _, err := router.GetMessages(append(request, token...)); !errors.Is(err, ErrReadTokenInvalid) {
+		t.Errorf("read of another address: %v", err)
+	}
+	// The token expires
+	own := ownReadRequest(key, token)
+	if err := router.readAuth.Check(addressKey(own[16:READ_REQUEST_SIZE]), own, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.readAuth.Check(addressKey(own[16:READ_REQUEST_SIZE]), own, time.Now().Add(time.Hour)); !errors.Is(err, ErrReadTokenInvalid) {
+		t.Error("expired token is accepted")
+	}
+}
+
+func TestReadAuthLock(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newReadAuthTestRouter(READ_AUTH_OPTIONAL)
+	if _, _, err := router.GetMessages(ownReadRequest(key, nil)); err != nil {
+		t.Errorf("read of an address without a lock: %v", err)
+	}
+
+	// A wrong signature and a reused nonce are rejected
+	request := authRequest(t, router, key, READ_AUTH_FLAG_LOCK)
+	wrong := append([]byte{}, request...)
+	wrong[len(wrong)-1] ^= 0xFF
+	if _, err := router.Authorize(wrong); !errors.Is(err, ErrReadAuthFailed) {
+		t.Errorf("wrong signature: %v", err)
+	}
+	token, err := router.Authorize(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := router.Authorize(request); !errors.Is(err, ErrReadAuthFailed) {
+		t.Errorf("reused nonce: %v", err)
+	}
+
+	// The locked address needs the token, other addresses do not
+	if _, _, err := router.GetMessages(ownReadRequest(key, nil)); !errors.Is(err, ErrReadTokenRequired) {
+		t.Errorf("read of the locked address without a token: %v", err)
+	}
+	if _, _, err := router.GetMessages(ownReadRequest(key, token)); err != nil {
+		t.Errorf("read of the locked address with the token: %v", err)
+	}
+	if _, _, err := router.GetMessages(benchReadRequest(1)); err != nil {
+		t.Errorf("read of another address: %v", err)
+	}
+
+	// The lock is removed after lock_lifetime_ms without reads
+	router.readAuth.Clear(time.Now().Add(time.Duration(router.readAuth.config.LockLifetimeMs+1000) * time.Millisecond))
+	if _, _, err := router.GetMessages(ownReadRequest(key, nil)); err != nil {
+		t.Errorf("read after the lock expired: %v", err)
+	}
+}
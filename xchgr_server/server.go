@@ -7,12 +7,15 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ipoluianov/gazer-billing-contract-eth/api"
+	"github.com/ipoluianov/gomisc/logger"
+	"github.com/ipoluianov/xchgr/blockchain/premium_client"
 )
 
 const (
@@ -36,6 +39,8 @@ type Router struct {
 	udr *Udr
 
 	addresses map[string]*AddressStorage
+	storage   Storage
+	billing   *Billing
 
 	// Statistics
 	stat       RouterStatistics
@@ -46,9 +51,22 @@ type Router struct {
 	lastDebugInfo []byte
 	lastStatInfo  []byte
 
-	contract01 *Contract01
+	premium premium_client.PremiumProvider
 
-	clearAddressesLastDT time.Time
+	limits Limits
+
+	forwarder      *Forwarder
+	powConfig      PowConfig
+	powController  *PowController
+	signatures     string
+	readAuth       *ReadAuth
+	ipLimiter      *RateLimiter
+	addressLimiter *RateLimiter
+	localPrefixes  map[string]bool
+	selfHost       string
+
+	clearAddressesLastDT      time.Time
+	updateLocalPrefixesLastDT time.Time
 }
 
 type RouterStatistics struct {
@@ -66,6 +84,38 @@ type RouterStatistics struct {
 	HttpRequestsD  int `json:"http_requests_d"`
 	HttpRequestsS  int `json:"http_requests_s"`
 	HttpRequestsF  int `json:"http_requests_f"`
+	HttpRequestsWS int `json:"http_requests_ws"`
+	HttpRequestsA  int `json:"http_requests_a"`
+
+	FramesRejectedLimit     int `json:"frames_rejected_limit"`
+	FramesRejectedSignature int `json:"frames_rejected_signature"`
+	RateLimitedIp           int `json:"rate_limited_ip"`
+	RateLimitedAddress      int `json:"rate_limited_address"`
+	FramesForwarded         int `json:"frames_forwarded"`
+	FramesForwardErrors     int `json:"frames_forward_errors"`
+	FramesForwardRetries    int `json:"frames_forward_retries"`
+	UdrRegistered           int `json:"udr_registered"`
+	UdrRejected             int `json:"udr_rejected"`
+	UdrRendezvousRequests   int `json:"udr_rendezvous_requests"`
+	UdrRendezvousCompleted  int `json:"udr_rendezvous_completed"`
+	UdrRendezvousNoPeer     int `json:"udr_rendezvous_no_peer"`
+	UdrRendezvousRejected   int `json:"udr_rendezvous_rejected"`
+	UdrSubscriptions        int `json:"udr_subscriptions"`
+	UdrFramesReceived       int `json:"udr_frames_received"`
+	UdrFramesRejected       int `json:"udr_frames_rejected"`
+	UdrPushed               int `json:"udr_pushed"`
+	UdrPushSkipped          int `json:"udr_push_skipped"`
+	StunRequests            int `json:"stun_requests"`
+	StunErrors              int `json:"stun_errors"`
+	NatClassified           int `json:"nat_classified"`
+	RelayAllocated          int `json:"relay_allocated"`
+	RelayActive             int `json:"relay_active"`
+	RelayDatagrams          int `json:"relay_datagrams"`
+	RelayBytes              int `json:"relay_bytes"`
+	RelayDropped            int `json:"relay_dropped"`
+	UdrRateLimitedIp        int `json:"udr_rate_limited_ip"`
+	PowAccepted             int `json:"pow_accepted"`
+	PowRejected             int `json:"pow_rejected"`
 
 	Contract01CounterSuccess int `json:"contract01_success"`
 	Contract01CounterError   int `json:"contract01_error"`
@@ -80,6 +130,8 @@ type RouterSpeedStatistics struct {
 	SpeedHttpRequestsNS int `json:"http_requests_ns"`
 	SpeedHttpRequestsD  int `json:"http_requests_d"`
 	SpeedHttpRequestsF  int `json:"http_requests_f"`
+	SpeedHttpRequestsWS int `json:"http_requests_ws"`
+	SpeedHttpRequestsA  int `json:"http_requests_a"`
 
 	SpeedFramesIn  int `json:"frames_in"`
 	SpeedFramesOut int `json:"frames_out"`
@@ -96,6 +148,12 @@ type RouterSpeedStatistics struct {
 	Contract01CounterError   int `json:"contract01_error"`
 	Contract01CounterRecords int `json:"contract01_records"`
 
+	PremiumSource string `json:"premium_source"`
+	PremiumAgeMs  int    `json:"premium_age_ms"`
+
+	PowComplexity int              `json:"pow_complexity"`
+	PowHistory    []PowHistoryItem `json:"pow_history"`
+
 	Version int `json:"version"`
 }
 
@@ -105,15 +163,27 @@ const (
 	STORING_TIMEOUT   = 60 * time.Second
 )
 
-func NewRouter() *Router {
+func NewRouter(config Config, storage Storage) *Router {
 	var c Router
-	c.network = NewNetworkDefault()
+	c.network = NewNetworkByConfig(config)
 	c.nonces = NewNonces(1000000)
+	c.nonces.SetComplexity(byte(config.Pow.Complexity))
+	c.powConfig = config.Pow
+	c.powController = NewPowController(config.Pow)
+	c.signatures = config.Signatures
+	c.readAuth = NewReadAuth(config.ReadAuth)
+	c.ipLimiter = NewRateLimiter(config.RateLimits.PerIp)
+	c.addressLimiter = NewRateLimiter(config.RateLimits.PerAddress)
 	c.addresses = make(map[string]*AddressStorage)
+	c.storage = storage
+	c.billing = NewBilling()
 
-	c.udr = NewUdr()
+	c.udr = NewUdr(&c, config.UdpListener, config.Udr, config.UdrPath())
 
-	c.contract01 = NewContract01()
+	c.premium = NewPremiumProvider(config)
+	c.limits = config.Limits
+	c.forwarder = NewForwarder(config.Forwarding, c.network)
+	c.selfHost = config.Forwarding.SelfHost
 
 	c.statLastDT = time.Now()
 	c.clearAddressesLastDT = time.Now()
@@ -132,9 +202,27 @@ func (c *Router) Start() error {
 		return errors.New("it is stopping")
 	}
 
+	err := c.storage.Open()
+	if err != nil {
+		return err
+	}
+	// The lifetime of the restored frames depends on the premium status
+	err = c.premium.Start()
+	if err != nil {
+		logger.Println("Router start premium provider error:", err)
+	}
+	err = c.storage.Load(c.restoreMessage)
+	if err != nil {
+		logger.Println("Router load storage error:", err)
+	}
+
+	c.started = true
+	if c.forwarder.Enabled() {
+		go c.updateLocalPrefixes()
+	}
+	c.forwarder.Start()
 	go c.thBackgroundOperations()
 
-	c.contract01.Start()
 	c.udr.Start()
 
 	return nil
@@ -150,11 +238,12 @@ func (c *Router) Stop() error {
 		c.mtx.Unlock()
 		return errors.New("already stopping")
 	}
-	c.contract01.Stop()
+	c.premium.Stop()
 	c.stopping = true
 	c.mtx.Unlock()
 
 	c.udr.Stop()
+	c.forwarder.Stop()
 
 	for {
 		c.mtx.Lock()
@@ -170,25 +259,60 @@ func (c *Router) Stop() error {
 	c.nonces = nil
 	c.mtx.Unlock()
 
-	return nil
+	return c.storage.Close()
+}
+
+// restoreMessage puts a message loaded from the storage back into its queue.
+// The message keeps its TouchDT: the lifetime counts from the original Put,
+// the downtime included, so expired messages are not restored.
+// It is called from Start with c.mtx locked.
+func (c *Router) restoreMessage(address string, msg *Message) {
+	if msg.id >= c.nextId {
+		c.nextId = msg.id + 1
+	}
+	tierLimits := c.tierLimits(c.limits, address)
+	if time.Since(msg.TouchDT) >= tierLimits.MessageLifetime() {
+		return
+	}
+	addressStorage, ok := c.addresses[address]
+	if !ok {
+		addressStorage = NewAddressStorage(tierLimits)
+		c.addresses[address] = addressStorage
+	}
+	addressStorage.Restore(msg)
+}
+
+// SetPremiumProvider replaces the provider created from the config. It must be called before Start.
+func (c *Router) SetPremiumProvider(premium premium_client.PremiumProvider) {
+	c.premium = premium
+}
+
+func (c *Router) SetLimits(limits Limits) {
+	c.mtx.Lock()
+	c.limits = limits
+	c.mtx.Unlock()
+}
+
+func (c *Router) Limits() (limits Limits) {
+	c.mtx.Lock()
+	limits = c.limits
+	c.mtx.Unlock()
+	return
+}
+
+// tierLimits returns the effective limits for the address (with or without #)
+func (c *Router) tierLimits(limits Limits, addr string) TierLimits {
+	return limits.Tier(c.premium.IsPremium(strings.Trim(addr, "#")))
 }
 
 func (c *Router) GetBillingInfo(addr string) (BillingInfo, error) {
-	isPremium := c.contract01.IsPremium(strings.Trim(addr, "#"))
 	if !strings.HasPrefix(addr, "#") {
 		addr = "#" + addr
 	}
 
-	var billingInfo BillingInfo
-	addressStorage, ok := c.addresses[addr]
-	if ok {
-		billingInfo = addressStorage.GetBillingInfo()
-	}
-
-	billingInfo.Limit = 10000
-	if isPremium {
-		billingInfo.Limit = 1000000000
-	}
+	limits := c.Limits()
+	billingInfo := c.billing.Get(addr, limits.BillingPeriod(), time.Now())
+	billingInfo.Limit = c.tierLimits(limits, addr).FramesPerPeriod
 	return billingInfo, nil
 }
 
@@ -203,17 +327,48 @@ func (c *Router) thBackgroundOperations() {
 		time.Sleep(50 * time.Millisecond)
 		c.thStatistics()
 		c.thClearAddresses()
+		c.thUpdateLocalPrefixes()
 	}
+
+	c.mtx.Lock()
+	c.started = false
+	c.mtx.Unlock()
 }
 
 func (c *Router) thStatistics() {
 	now := time.Now()
 	if now.Sub(c.statLastDT) >= 1*time.Second {
-		c.stat.Contract01CounterError = c.contract01.CounterError()
-		c.stat.Contract01CounterSuccess = c.contract01.CounterSuccess()
-		c.stat.Contract01CounterRecords = c.contract01.RecordsCount()
+		c.stat.Contract01CounterError = c.premium.CounterError()
+		c.stat.Contract01CounterSuccess = c.premium.CounterSuccess()
+		c.stat.Contract01CounterRecords = c.premium.RecordsCount()
+
+		forwarded, forwardErrors, forwardRetries := c.forwarder.Counters()
+		udrCounters := c.udr.Counters()
 
 		c.mtx.Lock()
+		c.stat.FramesForwarded = forwarded
+		c.stat.FramesForwardErrors = forwardErrors
+		c.stat.FramesForwardRetries = forwardRetries
+		c.stat.UdrRegistered = udrCounters.Registered
+		c.stat.UdrRejected = udrCounters.Rejected
+		c.stat.UdrRendezvousRequests = udrCounters.RendezvousRequests
+		c.stat.UdrRendezvousCompleted = udrCounters.RendezvousCompleted
+		c.stat.UdrRendezvousNoPeer = udrCounters.RendezvousNoPeer
+		c.stat.UdrRendezvousRejected = udrCounters.RendezvousRejected
+		c.stat.UdrSubscriptions = udrCounters.Subscriptions
+		c.stat.UdrFramesReceived = udrCounters.FramesReceived
+		c.stat.UdrFramesRejected = udrCounters.FramesRejected
+		c.stat.UdrPushed = udrCounters.Pushed
+		c.stat.UdrPushSkipped = udrCounters.PushSkipped
+		c.stat.StunRequests = udrCounters.StunRequests
+		c.stat.StunErrors = udrCounters.StunErrors
+		c.stat.NatClassified = udrCounters.NatClassified
+		c.stat.RelayAllocated = udrCounters.RelayAllocated
+		c.stat.RelayActive = udrCounters.RelayActive
+		c.stat.RelayDatagrams = udrCounters.RelayDatagrams
+		c.stat.RelayBytes = udrCounters.RelayBytes
+		c.stat.RelayDropped = udrCounters.RelayDropped
+		c.stat.UdrRateLimitedIp = udrCounters.RateLimitedIp
 		var stat RouterStatistics
 		stat.BytesIn = c.stat.BytesIn - c.statLast.BytesIn
 		stat.BytesOut = c.stat.BytesOut - c.statLast.BytesOut
@@ -227,6 +382,8 @@ func (c *Router) thStatistics() {
 		stat.HttpRequestsNS = c.stat.HttpRequestsNS - c.statLast.HttpRequestsNS
 		stat.HttpRequestsD = c.stat.HttpRequestsD - c.statLast.HttpRequestsD
 		stat.HttpRequestsF = c.stat.HttpRequestsF - c.statLast.HttpRequestsF
+		stat.HttpRequestsWS = c.stat.HttpRequestsWS - c.statLast.HttpRequestsWS
+		stat.HttpRequestsA = c.stat.HttpRequestsA - c.statLast.HttpRequestsA
 		stat.Contract01CounterSuccess = c.stat.Contract01CounterSuccess
 		stat.Contract01CounterError = c.stat.Contract01CounterError
 		stat.Contract01CounterRecords = c.stat.Contract01CounterRecords
@@ -250,11 +407,27 @@ func (c *Router) thStatistics() {
 		c.statSpeed.SpeedHttpRequestsNS = int(float64(stat.HttpRequestsNS) / now.Sub(c.statLastDT).Seconds())
 		c.statSpeed.SpeedHttpRequestsD = int(float64(stat.HttpRequestsD) / now.Sub(c.statLastDT).Seconds())
 		c.statSpeed.SpeedHttpRequestsF = int(float64(stat.HttpRequestsF) / now.Sub(c.statLastDT).Seconds())
+		c.statSpeed.SpeedHttpRequestsWS = int(float64(stat.HttpRequestsWS) / now.Sub(c.statLastDT).Seconds())
+		c.statSpeed.SpeedHttpRequestsA = int(float64(stat.HttpRequestsA) / now.Sub(c.statLastDT).Seconds())
 
 		c.statSpeed.Contract01CounterSuccess = stat.Contract01CounterSuccess
 		c.statSpeed.Contract01CounterError = stat.Contract01CounterError
 		c.statSpeed.Contract01CounterRecords = stat.Contract01CounterRecords
 
+		premiumSource, premiumAge := c.premium.Status()
+		c.statSpeed.PremiumSource = premiumSource
+		c.statSpeed.PremiumAgeMs = int(premiumAge / time.Millisecond)
+
+		if c.powController.Enabled() {
+			var load PowLoad
+			load.WritesPerSecond = c.statSpeed.SpeedFramesIn
+			load.QueuedBytes = c.queuedBytes()
+			load.CpuPercent = c.powController.CpuPercent(now)
+			c.nonces.SetComplexity(byte(c.powController.Update(now, load)))
+			c.statSpeed.PowHistory = c.powController.History()
+		}
+		c.statSpeed.PowComplexity = int(c.nonces.Complexity())
+
 		c.statSpeed.Version = VERSION
 
 		c.statLastDT = now
@@ -262,49 +435,249 @@ func (c *Router) thStatistics() {
 	}
 }
 
+func (c *Router) queuedBytes() int64 {
+	c.mtx.Lock()
+	addresses := make([]*AddressStorage, 0, len(c.addresses))
+	for _, a := range c.addresses {
+		addresses = append(addresses, a)
+	}
+	c.mtx.Unlock()
+
+	var size int64
+	for _, a := range addresses {
+		size += a.MessagesSize()
+	}
+	return size
+}
+
 func (c *Router) thClearAddresses() {
 	now := time.Now()
 	if now.Sub(c.clearAddressesLastDT) >= 1*time.Second {
 		c.mtx.Lock()
 		addresses := make([]*AddressStorage, 0)
 		for address, addressStorage := range c.addresses {
-			if now.Sub(addressStorage.TouchDT) > 30*time.Second {
+			if addressStorage.IsIdle(now) {
 				delete(c.addresses, address)
 				continue
 			}
 			addresses = append(addresses, addressStorage)
 		}
+		maxMessageLifetime := c.limits.MaxMessageLifetime()
+		billingPeriod := c.limits.BillingPeriod()
 		c.mtx.Unlock()
 
 		for _, a := range addresses {
 			a.Clear()
 		}
 
+		err := c.storage.Compact(now.Add(-maxMessageLifetime))
+		if err != nil {
+			logger.Println("Router compact storage error:", err)
+		}
+		c.billing.Clear(billingPeriod, now)
+		c.readAuth.Clear(now)
+		c.ipLimiter.Clear(now)
+		c.addressLimiter.Clear(now)
+
 		c.clearAddressesLastDT = now
 	}
 }
 
-func (c *Router) Put(frame []byte) error {
+func (c *Router) thUpdateLocalPrefixes() {
+	if !c.forwarder.Enabled() {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.updateLocalPrefixesLastDT) >= 30*time.Second {
+		c.updateLocalPrefixesLastDT = now
+		go c.updateLocalPrefixes()
+	}
+}
+
+// updateLocalPrefixes caches the ranges served by this host.
+// The cache stays empty (nil) until the network map is loaded.
+func (c *Router) updateLocalPrefixes() {
+	if !c.network.Loaded() {
+		return
+	}
+	localPrefixes := make(map[string]bool)
+	for _, prefix := range c.network.GetLocalPrefixes(c.selfHost) {
+		localPrefixes[prefix] = true
+	}
+	c.mtx.Lock()
+	if len(localPrefixes) == 0 && c.localPrefixes == nil {
+		logger.Println("Router: no range of the network map is served by this host, set forwarding.self_host")
+	}
+	c.localPrefixes = localPrefixes
+	c.mtx.Unlock()
+}
+
+// isLocalAddress reports whether the frames for the address must be stored on this router.
+// Addresses outside of all ranges are stored locally, all addresses are stored locally
+// until the ranges of this host are known.
+func (c *Router) isLocalAddress(addressBS []byte) bool {
+	prefix, ok := c.network.GetRangePrefixByAddress(addressBS)
+	if !ok {
+		return true
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.localPrefixes == nil || c.localPrefixes[prefix]
+}
+
+func addressKey(addressBS []byte) string {
+	return "#" + strings.ToLower(base32.StdEncoding.EncodeToString(addressBS))
+}
+
+// PutFrames stores a batch of length-prefixed frames.
+// The whole batch is validated before the first frame is stored.
+// pow is the proof of work for the batch, it may be empty if PoW is not required.
+func (c *Router) PutFrames(data []byte, pow []byte) error {
+	return c.putFrames(data, pow, true)
+}
+
+// PutForwardedFrames stores frames relayed by another router of the network.
+// They are already admitted by that router and are never forwarded again.
+func (c *Router) PutForwardedFrames(data []byte) error {
+	return c.putFrames(data, nil, false)
+}
+
+// IsNetworkHost reports whether the IP is a router of the network
+func (c *Router) IsNetworkHost(ip string) bool {
+	return c.network.IsNetworkHost(ip)
+}
+
+func (c *Router) putFrames(data []byte, pow []byte, canForward bool) error {
+	frames := make([]*Frame, 0)
+	for len(data) > 0 {
+		frame, rest, err := NextFrame(data)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+		data = rest
+	}
+
+	if canForward {
+		err := c.verifySignatures(frames)
+		if err != nil {
+			return err
+		}
+		err = c.allowFrames(frames)
+		if err != nil {
+			return err
+		}
+		err = c.checkPow(frames, pow)
+		if err != nil {
+			return err
+		}
+	}
+	err := c.chargeFrames(frames, canForward)
+	if err != nil {
+		return err
+	}
+
+	for _, frame := range frames {
+		err := c.putFrame(frame, canForward)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Router) Put(data []byte) error {
+	frame, err := ParseFrame(data)
+	if err != nil {
+		return err
+	}
+	return c.PutFrame(frame)
+}
+
+func (c *Router) PutFrame(frame *Frame) error {
+	err := c.chargeFrames([]*Frame{frame}, true)
+	if err != nil {
+		return err
+	}
+	return c.putFrame(frame, true)
+}
+
+// mustForward reports whether the frame is sent to another router of the network
+func (c *Router) mustForward(frame *Frame, canForward bool) bool {
+	return canForward && c.forwarder.Enabled() && !c.isLocalAddress(frame.DestAddress)
+}
+
+// putFrame stores or forwards a frame that is already charged
+func (c *Router) putFrame(frame *Frame, canForward bool) error {
+	if c.mustForward(frame, canForward) {
+		return c.forwarder.Forward(frame)
+	}
+
 	var ok bool
 	var addressStorage *AddressStorage
 
-	addressDestBS := frame[70:100]
-	addressDest := "#" + strings.ToLower(base32.StdEncoding.EncodeToString(addressDestBS))
-	//fmt.Println("<FRAME to ", addressDest, frame[8])
+	addressDest := frame.DestAddressString()
+	limits := c.Limits()
+	tierLimits := c.tierLimits(limits, addressDest)
 
 	c.mtx.Lock()
 	addressStorage, ok = c.addresses[addressDest]
 	if !ok || addressStorage == nil {
-		addressStorage = NewAddressStorage()
+		addressStorage = NewAddressStorage(tierLimits)
 		c.addresses[addressDest] = addressStorage
 	}
 	id := c.nextId
 	c.nextId++
 	c.mtx.Unlock()
 
-	err := addressStorage.Put(id, frame)
+	msg := NewMessage(id, frame.Data)
+	addressStorage.Put(msg, tierLimits)
+	err := c.storage.Append(addressDest, msg)
+	if err != nil {
+		logger.Println("Router storage append error:", err)
+	}
+	c.mtx.Lock()
 	c.stat.FramesIn++
-	c.stat.BytesIn += len(frame)
+	c.stat.BytesIn += len(frame.Data)
+	c.mtx.Unlock()
+	return nil
+}
+
+// ChargeFrame counts a datagram relayed from the address as a frame of its billing period
+func (c *Router) ChargeFrame(address string) error {
+	return c.chargeSenders(map[string]uint32{address: 1})
+}
+
+// chargeFrames counts the frames stored on this router in the billing periods of their senders.
+// Frames sent to another router are counted there.
+func (c *Router) chargeFrames(frames []*Frame, canForward bool) error {
+	counts := make(map[string]uint32)
+	for _, frame := range frames {
+		if !c.mustForward(frame, canForward) {
+			counts[frame.SrcAddressString()]++
+		}
+	}
+	return c.chargeSenders(counts)
+}
+
+// chargeSenders counts frames in the billing periods of the senders, all or nothing.
+// Frames without a source address share the counter of the zero address.
+// The source address is not authenticated unless the frame is signed (see signatures).
+func (c *Router) chargeSenders(counts map[string]uint32) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	limits := c.Limits()
+	senderLimits := make(map[string]uint32)
+	for address := range counts {
+		senderLimits[address] = c.tierLimits(limits, address).FramesPerPeriod
+	}
+	err := c.billing.ChargeAll(counts, senderLimits, limits.BillingPeriod(), time.Now())
+	if err != nil {
+		c.mtx.Lock()
+		c.stat.FramesRejectedLimit++
+		c.mtx.Unlock()
+	}
 	return err
 }
 
@@ -314,7 +687,7 @@ func (c *Router) GetMessages(frame []byte) (response []byte, count int, err erro
 	var addressStorage *AddressStorage
 
 	if len(frame) < 46 {
-		err = errors.New("wrong frame size")
+		err = fmt.Errorf("%w: wrong frame size", ErrMalformedFrame)
 		return
 	}
 
@@ -322,7 +695,11 @@ func (c *Router) GetMessages(frame []byte) (response []byte, count int, err erro
 	maxSize := binary.LittleEndian.Uint64(frame[8:])
 	addressSrcBS := frame[16 : 16+30]
 
-	addressSrc := "#" + strings.ToLower(base32.StdEncoding.EncodeToString(addressSrcBS))
+	addressSrc := addressKey(addressSrcBS)
+	err = c.readAuth.Check(addressSrc, frame, time.Now())
+	if err != nil {
+		return
+	}
 
 	c.mtx.Lock()
 	addressStorage, ok = c.addresses[addressSrc]
@@ -343,8 +720,69 @@ func (c *Router) GetMessages(frame []byte) (response []byte, count int, err erro
 		copy(response[8:], msgData)
 	}
 
+	c.mtx.Lock()
 	c.stat.FramesOut += count
 	c.stat.BytesOut += len(msgData)
+	c.mtx.Unlock()
+	return
+}
+
+// Subscribe registers a listener on the address storage for the address in the read request.
+// The storage is not evicted while it has listeners.
+func (c *Router) Subscribe(frame []byte) (addressStorage *AddressStorage, listener chan struct{}, err error) {
+	if len(frame) < 46 {
+		err = fmt.Errorf("%w: wrong frame size", ErrMalformedFrame)
+		return
+	}
+
+	address := addressKey(frame[16 : 16+30])
+	err = c.readAuth.Check(address, frame, time.Now())
+	if err != nil {
+		return
+	}
+	return c.SubscribeAddress(address)
+}
+
+// SubscribeAddress is Subscribe for callers that have already authenticated the owner of the address
+func (c *Router) SubscribeAddress(address string) (addressStorage *AddressStorage, listener chan struct{}, err error) {
+	err = c.allowAddress(address, 1)
+	if err != nil {
+		return
+	}
+	tierLimits := c.tierLimits(c.Limits(), address)
+
+	c.mtx.Lock()
+	addressStorage = c.addresses[address]
+	if addressStorage == nil {
+		addressStorage = NewAddressStorage(tierLimits)
+		c.addresses[address] = addressStorage
+	}
+	listener = addressStorage.AddListener()
+	c.mtx.Unlock()
+	return
+}
+
+func (c *Router) Unsubscribe(addressStorage *AddressStorage, listener chan struct{}) {
+	addressStorage.RemoveListener(listener)
+}
+
+// GetMessagesLimited returns whole frames stored for the address after afterId, up to maxSize bytes.
+// Frames larger than maxSize are passed over and counted in skipped.
+func (c *Router) GetMessagesLimited(address string, afterId uint64, maxSize int) (data []byte, lastId uint64, count int, skipped int) {
+	c.mtx.Lock()
+	addressStorage, ok := c.addresses[address]
+	c.mtx.Unlock()
+
+	if !ok || addressStorage == nil {
+		return nil, afterId, 0, 0
+	}
+
+	data, lastId, count, skipped = addressStorage.GetMessagesLimited(afterId, maxSize)
+
+	c.mtx.Lock()
+	c.stat.FramesOut += count
+	c.stat.BytesOut += len(data)
+	c.mtx.Unlock()
 	return
 }
 
@@ -447,6 +885,20 @@ func (c *Router) DeclareHttpRequestF() {
 	c.mtx.Unlock()
 }
 
+func (c *Router) DeclareHttpRequestWS() {
+	c.mtx.Lock()
+	c.stat.HttpRequests++
+	c.stat.HttpRequestsWS++
+	c.mtx.Unlock()
+}
+
+func (c *Router) DeclareHttpRequestA() {
+	c.mtx.Lock()
+	c.stat.HttpRequests++
+	c.stat.HttpRequestsA++
+	c.mtx.Unlock()
+}
+
 func (c *Router) buildDebugString() {
 	type AddressInfo struct {
 		Address      string `json:"address"`
@@ -461,6 +913,9 @@ func (c *Router) buildDebugString() {
 		Stat            RouterStatistics      `json:"stat_total"`
 		StatSpeed       RouterSpeedStatistics `json:"stat_in_second"`
 		Addresses       []AddressInfo         `json:"addresses"`
+		Limits          Limits                `json:"limits"`
+		LimitsFree      TierLimits            `json:"limits_free"`
+		LimitsPremium   TierLimits            `json:"limits_premium"`
 		Contract01Items []api.ShopRecord      `json:"contract01"`
 	}
 
@@ -470,19 +925,26 @@ func (c *Router) buildDebugString() {
 	di.NextMsgId = int(c.nextId)
 	di.Stat = c.stat
 	di.StatSpeed = c.statSpeed
+	di.Limits = c.limits
+	di.LimitsFree = c.limits.Tier(false)
+	di.LimitsPremium = c.limits.Tier(true)
 
 	di.Addresses = make([]AddressInfo, 0, len(c.addresses))
+	now := time.Now()
 	for address, a := range c.addresses {
 		var ai AddressInfo
 		ai.Address = address
 		ai.MessageCount = a.MessagesCount()
-		ai.Counter = int(a.billingInfo.Counter)
-		ai.Limit = int(a.billingInfo.Limit)
+		bi := c.billing.Get(address, c.limits.BillingPeriod(), now)
+		ai.Counter = int(bi.Counter)
+		ai.Limit = int(bi.Limit)
 		di.Addresses = append(di.Addresses, ai)
 	}
 	c.mtx.Unlock()
 
-	di.Contract01Items = c.contract01.Records()
+	if contract01, ok := c.premium.(*Contract01); ok {
+		di.Contract01Items = contract01.Records()
+	}
 
 	sort.Slice(di.Addresses, func(i, j int) bool {
 		return di.Addresses[i].Address < di.Addresses[j].Address
@@ -0,0 +1,130 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//////////////////////////////////////////////////////
+// Signed frame
+// [9] & FRAME_FLAG_SIGNED - the frame has a signature trailer:
+// [frame][public key][signature][uint16 pubLen][uint16 sigLen][SIGNATURE_MARKER]
+// Legacy clients may have any value in byte 9: a frame is signed only if
+// it has both the flag and the marker at the end.
+// public key - PKCS #1 DER of the sender RSA key,
+//              SHA256(public key)[:30] must be the source address
+// signature  - RSA PKCS #1 v1.5 over SHA256 of the frame
+//              without the trailer, with the cleared flag and
+//              the length of the frame without the trailer
+// The router stores the frame without the trailer.
+//////////////////////////////////////////////////////
+
+const (
+	FRAME_FLAGS_POS   = 9
+	FRAME_FLAG_SIGNED = byte(0x01)
+
+	SIGNATURE_MARKER       = "XSG1"
+	SIGNATURE_TRAILER_SIZE = 2 + 2 + len(SIGNATURE_MARKER)
+
+	SIGNATURES_OFF      = "off"
+	SIGNATURES_OPTIONAL = "optional"
+	SIGNATURES_REQUIRED = "required"
+)
+
+var (
+	ErrSignatureRequired = errors.New("frame signature required")
+	ErrSignatureInvalid  = errors.New("wrong frame signature")
+)
+
+func (c *Frame) IsSigned() bool {
+	if c.Data[FRAME_FLAGS_POS]&FRAME_FLAG_SIGNED == 0 || len(c.Data) < FRAME_HEADER_SIZE+SIGNATURE_TRAILER_SIZE {
+		return false
+	}
+	return string(c.Data[len(c.Data)-len(SIGNATURE_MARKER):]) == SIGNATURE_MARKER
+}
+
+// verifySignatures checks the signed frames and replaces them with frames without the trailer
+func (c *Router) verifySignatures(frames []*Frame) error {
+	if c.signatures == SIGNATURES_OFF {
+		return nil
+	}
+
+	for i, frame := range frames {
+		if !frame.IsSigned() {
+			if c.signatures == SIGNATURES_REQUIRED {
+				c.mtx.Lock()
+				c.stat.FramesRejectedSignature++
+				c.mtx.Unlock()
+				return ErrSignatureRequired
+			}
+			continue
+		}
+
+		unsignedFrame, err := verifyFrameSignature(frame)
+		if err != nil {
+			c.mtx.Lock()
+			c.stat.FramesRejectedSignature++
+			c.mtx.Unlock()
+			return err
+		}
+		frames[i] = unsignedFrame
+	}
+	return nil
+}
+
+func verifyFrameSignature(frame *Frame) (*Frame, error) {
+	data := frame.Data
+	if len(data) < FRAME_HEADER_SIZE+SIGNATURE_TRAILER_SIZE {
+		return nil, fmt.Errorf("%w: no trailer", ErrSignatureInvalid)
+	}
+	lengths := data[len(data)-SIGNATURE_TRAILER_SIZE:]
+	pubLen := int(binary.LittleEndian.Uint16(lengths[0:]))
+	sigLen := int(binary.LittleEndian.Uint16(lengths[2:]))
+	frameLen := len(data) - SIGNATURE_TRAILER_SIZE - pubLen - sigLen
+	if frameLen < FRAME_HEADER_SIZE {
+		return nil, fmt.Errorf("%w: wrong trailer", ErrSignatureInvalid)
+	}
+	publicKeyDer := data[frameLen : frameLen+pubLen]
+	signature := data[frameLen+pubLen : frameLen+pubLen+sigLen]
+
+	if !bytes.Equal(addressOfPublicKey(publicKeyDer), frame.SrcAddress) {
+		return nil, fmt.Errorf("%w: public key does not match the source address", ErrSignatureInvalid)
+	}
+
+	unsignedData := make([]byte, frameLen)
+	copy(unsignedData, data[:frameLen])
+	binary.LittleEndian.PutUint32(unsignedData[0:], uint32(frameLen))
+	unsignedData[FRAME_FLAGS_POS] &^= FRAME_FLAG_SIGNED
+
+	err := verifySignature(publicKeyDer, unsignedData, signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSignatureInvalid, err)
+	}
+	unsignedFrame, err := ParseFrame(unsignedData)
+	if err != nil {
+		return nil, err
+	}
+	unsignedFrame.verified = true
+	return unsignedFrame, nil
+}
+
+// addressOfPublicKey returns the address of the PKCS #1 DER public key: SHA256(public key)[:30]
+func addressOfPublicKey(publicKeyDer []byte) []byte {
+	hash := sha256.Sum256(publicKeyDer)
+	return hash[:AddressBytesSize]
+}
+
+// verifySignature checks the RSA PKCS #1 v1.5 signature of SHA256(data)
+func verifySignature(publicKeyDer []byte, data []byte, signature []byte) error {
+	publicKey, err := RSAPublicKeyFromDer(publicKeyDer)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature)
+}
@@ -0,0 +1,115 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// signTestFrame appends the signature trailer, the source address is set from the key
+func signTestFrame(t *testing.T, key *rsa.PrivateKey, frame []byte) []byte {
+	pub := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	copy(frame[FRAME_SRC_ADDRESS_POS:], addressOfPublicKey(pub))
+	hash := sha256.Sum256(frame)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := append([]byte{}, frame...)
+	signed[FRAME_FLAGS_POS] |= FRAME_FLAG_SIGNED
+	signed = append(signed, pub...)
+	signed = append(signed, signature...)
+	lengths := make([]byte, 4)
+	binary.LittleEndian.PutUint16(lengths[0:], uint16(len(pub)))
+	binary.LittleEndian.PutUint16(lengths[2:], uint16(len(signature)))
+	signed = append(signed, lengths...)
+	signed = append(signed, SIGNATURE_MARKER...)
+	binary.LittleEndian.PutUint32(signed[0:], uint32(len(signed)))
+	return signed
+}
+
+func lastStoredFrame(router *Router, address string) []byte {
+	router.mtx.Lock()
+	addressStorage := router.addresses[address]
+	router.mtx.Unlock()
+	if addressStorage == nil {
+		return nil
+	}
+	addressStorage.mtx.Lock()
+	defer addressStorage.mtx.Unlock()
+	if len(addressStorage.messages) == 0 {
+		return nil
+	}
+	return addressStorage.messages[len(addressStorage.messages)-1].data
+}
+
+func TestFrameSignatures(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := udrTestFrame(0, 0x01, []byte("payload"))
+	frame[FRAME_TYPE_POS] = 0x10
+	signed := signTestFrame(t, key, frame)
+
+	router := newBenchRouter()
+	router.signatures = SIGNATURES_REQUIRED
+	if err := router.PutFrames(frame, nil); !errors.Is(err, ErrSignatureRequired) {
+		t.Errorf("unsigned frame: %v", err)
+	}
+	if err := router.PutFrames(signed, nil); err != nil {
+		t.Fatalf("signed frame: %v", err)
+	}
+	// The trailer is removed, the flag is cleared
+	if stored := lastStoredFrame(router, premiumTestAddress(0x01)); !bytes.Equal(stored, frame) {
+		t.Errorf("stored frame %x, expected %x", stored, frame)
+	}
+
+	tampered := append([]byte{}, signed...)
+	tampered[FRAME_HEADER_SIZE] ^= 0xFF
+	if err := router.PutFrames(tampered, nil); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("changed payload: %v", err)
+	}
+	// A valid signature of another key does not prove the source address
+	forged := signTestFrame(t, otherKey, append([]byte{}, frame...))
+	copy(forged[FRAME_SRC_ADDRESS_POS:], signed[FRAME_SRC_ADDRESS_POS:FRAME_SRC_ADDRESS_POS+AddressBytesSize])
+	if err := router.PutFrames(forged, nil); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("key of another address: %v", err)
+	}
+	// A batch is rejected as a whole
+	count := storedMessages(router, premiumTestAddress(0x01))
+	if err := router.PutFrames(append(append([]byte{}, signed...), tampered...), nil); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("batch with a wrong signature: %v", err)
+	}
+	if n := storedMessages(router, premiumTestAddress(0x01)); n != count {
+		t.Errorf("%d frames of a rejected batch are stored", n-count)
+	}
+
+	router.signatures = SIGNATURES_OPTIONAL
+	if err := router.PutFrames(frame, nil); err != nil {
+		t.Errorf("unsigned frame in the optional mode: %v", err)
+	}
+	if err := router.PutFrames(tampered, nil); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("changed payload in the optional mode: %v", err)
+	}
+
+	// Legacy clients: the frame is stored as is
+	router.signatures = SIGNATURES_OFF
+	if err := router.PutFrames(tampered, nil); err != nil {
+		t.Fatalf("signatures off: %v", err)
+	}
+	if stored := lastStoredFrame(router, premiumTestAddress(0x01)); !bytes.Equal(stored, tampered) {
+		t.Errorf("stored frame %x, expected %x", stored, tampered)
+	}
+}
@@ -0,0 +1,66 @@
+package xchgr_server
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	STORAGE_TYPE_MEMORY = "memory"
+	STORAGE_TYPE_FILE   = "file"
+)
+
+// Storage is the backend behind AddressStorage queues.
+// AddressStorage always keeps the queued messages in memory for reading,
+// the storage decides whether they survive a restart.
+type Storage interface {
+	Open() error
+	Close() error
+
+	// Load calls handler for every stored message in the order they were appended
+	Load(handler func(address string, msg *Message)) error
+	Append(address string, msg *Message) error
+
+	// Compact drops the messages stored before the time
+	Compact(before time.Time) error
+}
+
+func NewStorage(storageType string, dir string) (Storage, error) {
+	switch storageType {
+	case "", STORAGE_TYPE_MEMORY:
+		return NewMemoryStorage(), nil
+	case STORAGE_TYPE_FILE:
+		return NewFileStorage(dir), nil
+	}
+	return nil, errors.New("unknown storage type: " + storageType)
+}
+
+// MemoryStorage keeps nothing outside of AddressStorage:
+// queued messages are lost on restart
+type MemoryStorage struct {
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	var c MemoryStorage
+	return &c
+}
+
+func (c *MemoryStorage) Open() error {
+	return nil
+}
+
+func (c *MemoryStorage) Close() error {
+	return nil
+}
+
+func (c *MemoryStorage) Load(handler func(address string, msg *Message)) error {
+	return nil
+}
+
+func (c *MemoryStorage) Append(address string, msg *Message) error {
+	return nil
+}
+
+func (c *MemoryStorage) Compact(before time.Time) error {
+	return nil
+}
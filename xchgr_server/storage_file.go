@@ -0,0 +1,238 @@
+package xchgr_server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipoluianov/gomisc/logger"
+)
+
+//////////////////////////////////////////////////////
+// Append-only spool
+// The directory contains segment files <UnixNano>.spool
+// Record:
+// [L][L][L][L] - record length without this field
+// [I]x8        - message id
+// [T]x8        - message time (UnixNano)
+// [A]          - address length
+// [address][frame]
+// A segment is deleted when all its records are expired
+//////////////////////////////////////////////////////
+
+const (
+	FILE_STORAGE_EXT              = ".spool"
+	FILE_STORAGE_SEGMENT_PERIOD   = 1 * time.Minute
+	FILE_STORAGE_SEGMENT_MAX_SIZE = 64 * 1024 * 1024
+	FILE_STORAGE_RECORD_HEADER    = 8 + 8 + 1
+)
+
+type fileStorageSegment struct {
+	fileName string
+	lastDT   time.Time
+}
+
+type FileStorage struct {
+	mtx sync.Mutex
+	dir string
+
+	segments []*fileStorageSegment
+
+	file        *os.File
+	fileBeginDT time.Time
+	fileSize    int64
+}
+
+func NewFileStorage(dir string) *FileStorage {
+	var c FileStorage
+	c.dir = dir
+	c.segments = make([]*fileStorageSegment, 0)
+	return &c
+}
+
+func (c *FileStorage) Open() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	err := os.MkdirAll(c.dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	c.segments = make([]*fileStorageSegment, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), FILE_STORAGE_EXT) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		var segment fileStorageSegment
+		segment.fileName = filepath.Join(c.dir, entry.Name())
+		segment.lastDT = info.ModTime()
+		c.segments = append(c.segments, &segment)
+	}
+	sort.Slice(c.segments, func(i, j int) bool {
+		return c.segments[i].fileName < c.segments[j].fileName
+	})
+	return nil
+}
+
+func (c *FileStorage) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.closeFile()
+}
+
+func (c *FileStorage) Load(handler func(address string, msg *Message)) error {
+	c.mtx.Lock()
+	segments := make([]*fileStorageSegment, len(c.segments))
+	copy(segments, c.segments)
+	c.mtx.Unlock()
+
+	for _, segment := range segments {
+		err := c.loadSegment(segment.fileName, handler)
+		if err != nil {
+			logger.Println("FileStorage load", segment.fileName, "error:", err)
+		}
+	}
+	return nil
+}
+
+func (c *FileStorage) loadSegment(fileName string, handler func(address string, msg *Message)) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	lenBS := make([]byte, 4)
+	for {
+		_, err = io.ReadFull(reader, lenBS)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		recordLen := int(binary.LittleEndian.Uint32(lenBS))
+		if recordLen < FILE_STORAGE_RECORD_HEADER || recordLen > FILE_STORAGE_RECORD_HEADER+255+FRAME_MAX_SIZE {
+			return fmt.Errorf("wrong record length %d", recordLen)
+		}
+		record := make([]byte, recordLen)
+		_, err = io.ReadFull(reader, record)
+		if err != nil {
+			// The tail of the segment was not written completely
+			return err
+		}
+
+		addressLen := int(record[16])
+		if FILE_STORAGE_RECORD_HEADER+addressLen > recordLen {
+			return fmt.Errorf("wrong address length %d", addressLen)
+		}
+		address := string(record[FILE_STORAGE_RECORD_HEADER : FILE_STORAGE_RECORD_HEADER+addressLen])
+		msg := NewMessage(binary.LittleEndian.Uint64(record[0:]), record[FILE_STORAGE_RECORD_HEADER+addressLen:])
+		msg.TouchDT = time.Unix(0, int64(binary.LittleEndian.Uint64(record[8:])))
+		handler(address, msg)
+	}
+}
+
+func (c *FileStorage) Append(address string, msg *Message) error {
+	if len(address) > 255 {
+		return fmt.Errorf("address is too long: %d", len(address))
+	}
+
+	recordLen := FILE_STORAGE_RECORD_HEADER + len(address) + len(msg.data)
+	record := make([]byte, 4+recordLen)
+	binary.LittleEndian.PutUint32(record[0:], uint32(recordLen))
+	binary.LittleEndian.PutUint64(record[4:], msg.id)
+	binary.LittleEndian.PutUint64(record[12:], uint64(msg.TouchDT.UnixNano()))
+	record[20] = byte(len(address))
+	copy(record[21:], address)
+	copy(record[21+len(address):], msg.data)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	err := c.rotate(msg.TouchDT)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.file.Write(record)
+	if err != nil {
+		return err
+	}
+	c.fileSize += int64(len(record))
+	c.segments[len(c.segments)-1].lastDT = msg.TouchDT
+	return nil
+}
+
+func (c *FileStorage) Compact(before time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	segments := make([]*fileStorageSegment, 0, len(c.segments))
+	for i, segment := range c.segments {
+		isCurrent := c.file != nil && i == len(c.segments)-1
+		if !isCurrent && segment.lastDT.Before(before) {
+			err := os.Remove(segment.fileName)
+			if err != nil && !os.IsNotExist(err) {
+				logger.Println("FileStorage compact", segment.fileName, "error:", err)
+				segments = append(segments, segment)
+			}
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	c.segments = segments
+	return nil
+}
+
+// rotate opens a new segment when the current one is too old or too big
+func (c *FileStorage) rotate(now time.Time) error {
+	if c.file != nil && now.Sub(c.fileBeginDT) < FILE_STORAGE_SEGMENT_PERIOD && c.fileSize < FILE_STORAGE_SEGMENT_MAX_SIZE {
+		return nil
+	}
+
+	err := c.closeFile()
+	if err != nil {
+		logger.Println("FileStorage close segment error:", err)
+	}
+
+	var segment fileStorageSegment
+	segment.fileName = filepath.Join(c.dir, fmt.Sprintf("%020d%s", now.UnixNano(), FILE_STORAGE_EXT))
+	segment.lastDT = now
+	c.file, err = os.OpenFile(segment.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		c.file = nil
+		return err
+	}
+	c.fileBeginDT = now
+	c.fileSize = 0
+	c.segments = append(c.segments, &segment)
+	return nil
+}
+
+func (c *FileStorage) closeFile() error {
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
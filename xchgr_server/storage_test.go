@@ -0,0 +1,184 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipoluianov/xchgr/blockchain/premium_client"
+)
+
+// startedProvider knows the premium addresses only after Start, like a provider with a snapshot
+type startedProvider struct {
+	*premium_client.MockProvider
+	address string
+}
+
+func (c *startedProvider) Start() error {
+	c.Set(c.address, time.Time{})
+	return nil
+}
+
+func newStorageTestRouter(t *testing.T, dir string, premium premium_client.PremiumProvider) *Router {
+	config := DefaultConfig()
+	config.UdpListener = ""
+	config.Limits.MessageLifetimeMs = 50
+	config.Limits.Premium.MessageLifetimeMs = 60000
+	storage, _ := NewStorage(STORAGE_TYPE_FILE, dir)
+	router := NewRouter(config, storage)
+	router.SetPremiumProvider(premium)
+	if err := router.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func storedMessages(router *Router, address string) int {
+	router.mtx.Lock()
+	defer router.mtx.Unlock()
+	addressStorage, ok := router.addresses[address]
+	if !ok {
+		return 0
+	}
+	return len(addressStorage.messages)
+}
+
+func TestRestorePremiumLifetime(t *testing.T) {
+	dir := t.TempDir()
+	premiumAddress := premiumTestAddress(0xA0)
+	freeAddress := premiumTestAddress(0xB0)
+
+	premium := premium_client.NewMockProvider()
+	premium.Set(premiumAddress, time.Time{})
+	router := newStorageTestRouter(t, dir, premium)
+	for _, dest := range []byte{0xA0, 0xB0} {
+		if err := router.PutFrames(premiumTestFrame(0x01, dest), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = router.Stop()
+
+	// Older than the free lifetime, younger than the premium one
+	time.Sleep(100 * time.Millisecond)
+	router = newStorageTestRouter(t, dir, &startedProvider{premium_client.NewMockProvider(), premiumAddress})
+	defer router.Stop()
+	if n := storedMessages(router, premiumAddress); n != 1 {
+		t.Errorf("%d frames of the premium address restored, expected 1", n)
+	}
+	if n := storedMessages(router, freeAddress); n != 0 {
+		t.Errorf("%d frames of the free address restored, expected 0", n)
+	}
+}
+
+type fileStorageTestRecord struct {
+	address string
+	msg     *Message
+}
+
+func loadFileStorage(t *testing.T, dir string) []fileStorageTestRecord {
+	storage := NewFileStorage(dir)
+	if err := storage.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	records := make([]fileStorageTestRecord, 0)
+	_ = storage.Load(func(address string, msg *Message) {
+		records = append(records, fileStorageTestRecord{address, msg})
+	})
+	return records
+}
+
+func spoolSegments(t *testing.T, dir string) []string {
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+FILE_STORAGE_EXT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segments
+}
+
+func TestFileStorageRestore(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewFileStorage(dir)
+	if err := storage.Open(); err != nil {
+		t.Fatal(err)
+	}
+	written := []fileStorageTestRecord{
+		{premiumTestAddress(0x01), NewMessage(1, premiumTestFrame(0xA0, 0x01))},
+		{premiumTestAddress(0x02), NewMessage(2, premiumTestFrame(0xA0, 0x02))},
+		{premiumTestAddress(0x01), NewMessage(3, premiumTestFrame(0xA1, 0x01))},
+	}
+	for _, record := range written {
+		if err := storage.Append(record.address, record.msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = storage.Close()
+
+	check := func(records []fileStorageTestRecord) {
+		if len(records) != len(written) {
+			t.Fatalf("%d records restored, expected %d", len(records), len(written))
+		}
+		for i, record := range records {
+			w := written[i]
+			if record.address != w.address || record.msg.id != w.msg.id || !bytes.Equal(record.msg.data, w.msg.data) || !record.msg.TouchDT.Equal(w.msg.TouchDT) {
+				t.Errorf("record %d: %s %d %v, expected %s %d %v", i, record.address, record.msg.id, record.msg.TouchDT, w.address, w.msg.id, w.msg.TouchDT)
+			}
+		}
+	}
+	check(loadFileStorage(t, dir))
+
+	// The tail of a record written before a crash is skipped
+	segments := spoolSegments(t, dir)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{100, 0, 0, 0, 1, 2, 3})
+	f.Close()
+	check(loadFileStorage(t, dir))
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewFileStorage(dir)
+	if err := storage.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	// Every message is in its own segment
+	begin := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		msg := NewMessage(uint64(i), premiumTestFrame(0xA0, 0x01))
+		msg.TouchDT = begin.Add(time.Duration(i) * 2 * FILE_STORAGE_SEGMENT_PERIOD)
+		if err := storage.Append(premiumTestAddress(0x01), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(spoolSegments(t, dir)); n != 3 {
+		t.Fatalf("%d segments, expected 3", n)
+	}
+
+	if err := storage.Compact(begin.Add(3 * FILE_STORAGE_SEGMENT_PERIOD)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spoolSegments(t, dir)); n != 1 {
+		t.Errorf("%d segments after the compaction, expected 1", n)
+	}
+	// The current segment is kept while it is written
+	if err := storage.Compact(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spoolSegments(t, dir)); n != 1 {
+		t.Errorf("%d segments after the compaction of the current one, expected 1", n)
+	}
+	_ = storage.Close()
+	if err := storage.Compact(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if records := loadFileStorage(t, dir); len(records) != 0 {
+		t.Errorf("%d records restored after the compaction", len(records))
+	}
+}
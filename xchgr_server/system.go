@@ -1,22 +1,36 @@
 package xchgr_server
 
 type System struct {
-	port       int
+	config     Config
 	router     *Router
 	httpServer *HttpServer
 }
 
-func NewSystem(port int) *System {
+func NewSystem(config Config) (*System, error) {
 	var c System
-	c.port = port
-	c.router = NewRouter()
+	c.config = config
+	storage, err := NewStorage(config.Storage.Type, config.StoragePath())
+	if err != nil {
+		return nil, err
+	}
+	c.router = NewRouter(config, storage)
 	c.httpServer = NewHttpServer()
-	return &c
+	return &c, nil
 }
 
-func (c *System) Start() {
-	c.router.Start()
-	c.httpServer.Start(c.router, c.port)
+// Start does not serve HTTP if the router (and its storage) can not be started
+// and fails if no HTTP listener can be started
+func (c *System) Start() error {
+	err := c.router.Start()
+	if err != nil {
+		return err
+	}
+	err = c.httpServer.Start(c.router, c.config)
+	if err != nil {
+		_ = c.router.Stop()
+		return err
+	}
+	return nil
 }
 
 func (c *System) Stop() {
@@ -0,0 +1,86 @@
+package xchgr_server
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipoluianov/gomisc/logger"
+)
+
+// CertReloader serves the certificate for TLS listeners
+// and reloads it when the certificate or key file changes
+type CertReloader struct {
+	mtx         sync.Mutex
+	certFile    string
+	keyFile     string
+	period      time.Duration
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheckDT time.Time
+}
+
+func NewCertReloader(certFile string, keyFile string, period time.Duration) (*CertReloader, error) {
+	var c CertReloader
+	c.certFile = certFile
+	c.keyFile = keyFile
+	c.period = period
+	err := c.load(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *CertReloader) load(now time.Time) error {
+	certModTime, keyModTime, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.certModTime = certModTime
+	c.keyModTime = keyModTime
+	c.lastCheckDT = now
+	return nil
+}
+
+func (c *CertReloader) modTimes() (certModTime time.Time, keyModTime time.Time, err error) {
+	certStat, err := os.Stat(c.certFile)
+	if err != nil {
+		return
+	}
+	keyStat, err := os.Stat(c.keyFile)
+	if err != nil {
+		return
+	}
+	return certStat.ModTime(), keyStat.ModTime(), nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate.
+// The files are checked at most once per period, on errors the previous certificate is kept.
+func (c *CertReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastCheckDT) >= c.period {
+		c.lastCheckDT = now
+		certModTime, keyModTime, err := c.modTimes()
+		if err == nil && (!certModTime.Equal(c.certModTime) || !keyModTime.Equal(c.keyModTime)) {
+			err = c.load(now)
+			if err == nil {
+				logger.Println("HttpServer certificate reloaded", c.certFile)
+			}
+		}
+		if err != nil {
+			logger.Println("HttpServer certificate reload error:", err)
+		}
+	}
+	return c.cert, nil
+}
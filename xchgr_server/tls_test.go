@@ -0,0 +1,116 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate with the serial number,
+// the files get the modification time
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), modTime)
+	writeTestFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), modTime)
+}
+
+func writeTestFile(t *testing.T, fileName string, content []byte, modTime time.Time) {
+	if err := os.WriteFile(fileName, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartWithoutCertificate(t *testing.T) {
+	config := DefaultConfig()
+	config.TlsListeners = []string{"127.0.0.1:0"}
+	config.Tls.CertFile = filepath.Join(t.TempDir(), "missing.crt")
+	config.Tls.KeyFile = filepath.Join(t.TempDir(), "missing.key")
+
+	// Only TLS listeners: nothing can be served
+	config.HttpListeners = nil
+	httpServer := NewHttpServer()
+	if err := httpServer.Start(newBenchRouter(), config); err == nil {
+		t.Error("started without any listener")
+	}
+
+	// The HTTP listeners are served without the certificate
+	config.HttpListeners = []string{"127.0.0.1:0"}
+	httpServer = NewHttpServer()
+	if err := httpServer.Start(newBenchRouter(), config); err != nil {
+		t.Errorf("HTTP listeners are not started: %v", err)
+	}
+	httpServer.Stop()
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	if _, err := NewCertReloader(certFile, keyFile, 0); err == nil {
+		t.Error("started without the certificate files")
+	}
+
+	modTime := time.Now().Add(-time.Hour)
+	writeTestCertificate(t, certFile, keyFile, 1, modTime)
+	reloader, err := NewCertReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+	if n := serial(); n != 1 {
+		t.Errorf("serial %d", n)
+	}
+
+	// The changed files are loaded
+	modTime = modTime.Add(time.Minute)
+	writeTestCertificate(t, certFile, keyFile, 2, modTime)
+	if n := serial(); n != 2 {
+		t.Errorf("serial %d after the reload", n)
+	}
+
+	// A broken certificate keeps the previous one
+	modTime = modTime.Add(time.Minute)
+	writeTestFile(t, certFile, bytes.Repeat([]byte("x"), 100), modTime)
+	if n := serial(); n != 2 {
+		t.Errorf("serial %d after a broken file", n)
+	}
+}
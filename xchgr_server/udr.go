@@ -1,42 +1,315 @@
 package xchgr_server
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"net"
+	"os"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ipoluianov/gomisc/logger"
 )
 
+//////////////////////////////////////////////////////
+// UDR - UDP endpoint registry
+// Every packet: [type 1][payload]
+// UDR_PACKET_REGISTER:
+//   [address 30][timestamp 8][uint16 pubLen][public key][signature]
+//   timestamp  - unix time in milliseconds (LE)
+//   public key - PKCS #1 DER, SHA256(public key)[:30] must be the address
+//   signature  - RSA PKCS #1 v1.5 over SHA256([type][address][timestamp])
+//   The timestamp must be within max_clock_skew_ms of the router time
+//   and greater than the timestamp of the previous registration.
+// UDR_PACKET_REGISTER_ACK (router -> client):
+//   [status 1][address 30][timestamp 8][ttl ms uint32]
+// Signed packets that are malformed or have a wrong signature are dropped
+// without a reply, so the router never answers a spoofed source.
+// A replayed packet gets UDR_STATUS_STALE only at the registered endpoint.
+// Every source IP is limited by per_ip before anything is checked.
+// UDR_PACKET_CONNECT (rendezvous request A -> router):
+//   [address A 30][address B 30][timestamp 8][uint16 pubLen][public key A][signature]
+//   signature  - RSA PKCS #1 v1.5 over SHA256([type][address A][address B][timestamp])
+//   A must be registered, the timestamp follows the same rules as for
+//   UDR_PACKET_REGISTER. The source of the packet becomes the endpoint of A.
+// UDR_PACKET_CONNECT_ACK (router -> A):
+//   [status 1][address B 30][timestamp 8]
+// UDR_PACKET_PEER (router -> A and router -> B, sent together on success):
+//   [peer address 30][peer ip 16][peer port uint16][timestamp 8]
+//   A receives the endpoint of B, B receives the endpoint of A;
+//   both start sending to each other right away to punch the NATs
+// Frame delivery packets are described in udr_frames.go,
+// STUN and NAT classification in udr_stun.go, the relay in udr_relay.go
+//////////////////////////////////////////////////////
+
+const (
+	UDR_PACKET_REGISTER     = byte(0x01)
+	UDR_PACKET_REGISTER_ACK = byte(0x02)
+	UDR_PACKET_CONNECT      = byte(0x03)
+	UDR_PACKET_CONNECT_ACK  = byte(0x04)
+	UDR_PACKET_PEER         = byte(0x05)
+
+	UDR_STATUS_OK         = byte(0x00)
+	UDR_STATUS_MALFORMED  = byte(0x01)
+	UDR_STATUS_BAD_SIGN   = byte(0x02)
+	UDR_STATUS_STALE      = byte(0x03)
+	UDR_STATUS_TABLE_FULL = byte(0x04)
+	UDR_STATUS_NOT_REG    = byte(0x05)
+	UDR_STATUS_NO_PEER    = byte(0x06)
+	UDR_STATUS_RATE_LIMIT = byte(0x07)
+	UDR_REGISTER_SIGNED   = 1 + AddressBytesSize + 8
+	UDR_REGISTER_HEADER   = UDR_REGISTER_SIGNED + 2
+	UDR_CONNECT_SIGNED    = 1 + AddressBytesSize + AddressBytesSize + 8
+	UDR_CONNECT_HEADER    = UDR_CONNECT_SIGNED + 2
+	UDR_READ_BUFFER_SIZE  = 65536
+	UDR_SAVE_PERIOD       = 10 * time.Second
+	UDR_ACK_SIZE          = 1 + 1 + AddressBytesSize + 8 + 4
+	UDR_CONNECT_ACK_SIZE  = 1 + 1 + AddressBytesSize + 8
+	UDR_PEER_SIZE         = 1 + AddressBytesSize + net.IPv6len + 2 + 8
+)
+
+type UdrConfig struct {
+	TtlMs          int `json:"ttl_ms"`
+	MaxEntries     int `json:"max_entries"`
+	MaxClockSkewMs int `json:"max_clock_skew_ms"`
+	// Minimal interval between rendezvous requests of one address
+	ConnectIntervalMs int `json:"connect_interval_ms"`
+	// Maximal size of datagrams with frames, in both directions
+	MaxDatagramSize int `json:"max_datagram_size"`
+	// Optional second UDP port for STUN CHANGE-REQUEST and NAT classification
+	AltListener string         `json:"alt_listener"`
+	Relay       UdrRelayConfig `json:"relay"`
+	// Datagrams of a source IP (relay data is limited by the relay bandwidth)
+	PerIp RateLimitConfig `json:"per_ip"`
+}
+
 type Udr struct {
-	mtx      sync.Mutex
-	db       map[string]string
-	stopping bool
+	mtx           sync.Mutex
+	router        *Router
+	listenAddress string
+	config        UdrConfig
+	fileName      string
+	db            map[string]UdrRecord
+	changed       bool
+	conn          *net.UDPConn
+	altConn       *net.UDPConn
+	stopping      bool
+	lastConnect   map[string]time.Time
+	subscriptions map[string]*udrSubscription
+	stunSessions  map[string]*stunSession
+	stunNonces    map[string]*stunSession
+	relayPairs    map[string]*udrAllocation
+	relayChannels map[uint64]*udrAllocation
+	// Allocations asked for by an address
+	relayAsked   map[string]int
+	relayLimiter *RateLimiter
+	ipLimiter    *RateLimiter
+	closed       chan struct{}
+
+	counters UdrCounters
+}
+
+type UdrCounters struct {
+	Registered          int
+	Rejected            int
+	RendezvousRequests  int
+	RendezvousCompleted int
+	RendezvousNoPeer    int
+	RendezvousRejected  int
+	Subscriptions       int
+	FramesReceived      int
+	FramesRejected      int
+	Pushed              int
+	PushSkipped         int
+	StunRequests        int
+	StunErrors          int
+	NatClassified       int
+	RelayAllocated      int
+	RelayActive         int
+	RelayDatagrams      int
+	RelayBytes          int
+	RelayDropped        int
+	RateLimitedIp       int
 }
 
 type UdrRecord struct {
 	XchgAddress string
 	IpPoint     string
+	Timestamp   int64
+	Expires     time.Time
+	NatType     string
+	NatUpdated  time.Time
 }
 
 type UdrState struct {
 	Items []UdrRecord
 }
 
-func NewUdr() *Udr {
+func NewUdr(router *Router, listenAddress string, config UdrConfig, fileName string) *Udr {
 	var c Udr
-	c.db = make(map[string]string)
+	c.router = router
+	c.listenAddress = listenAddress
+	c.config = config
+	c.fileName = fileName
+	c.db = make(map[string]UdrRecord)
+	c.lastConnect = make(map[string]time.Time)
+	c.subscriptions = make(map[string]*udrSubscription)
+	c.stunSessions = make(map[string]*stunSession)
+	c.stunNonces = make(map[string]*stunSession)
+	c.relayPairs = make(map[string]*udrAllocation)
+	c.relayChannels = make(map[uint64]*udrAllocation)
+	c.relayAsked = make(map[string]int)
+	c.relayLimiter = NewRateLimiter(RateLimitConfig{
+		Rate:  float64(config.Relay.BandwidthBytesPerSecond),
+		Burst: config.Relay.BurstBytes,
+	})
+	c.ipLimiter = NewRateLimiter(config.PerIp)
+	c.closed = make(chan struct{})
 	return &c
 }
 
 func (c *Udr) Start() {
+	if c.listenAddress == "" {
+		logger.Println("UDR disabled")
+		return
+	}
 	logger.Println("UDR starting")
-	go c.th()
+	c.load()
+
+	addr, err := net.ResolveUDPAddr("udp", c.listenAddress)
+	if err != nil {
+		logger.Println("UDR ERROR:", err)
+		return
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		logger.Println("UDR ERROR:", err)
+		return
+	}
+	c.mtx.Lock()
+	c.conn = conn
+	c.mtx.Unlock()
+	go c.th(conn, false)
+	go c.thSave()
+
+	if c.config.AltListener == "" {
+		return
+	}
+	addr, err = net.ResolveUDPAddr("udp", c.config.AltListener)
+	if err == nil {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		logger.Println("UDR alternate port ERROR:", err)
+		return
+	}
+	c.mtx.Lock()
+	c.altConn = conn
+	c.mtx.Unlock()
+	go c.th(conn, true)
 }
 
 func (c *Udr) Stop() {
+	c.mtx.Lock()
+	if c.stopping {
+		c.mtx.Unlock()
+		return
+	}
 	c.stopping = true
+	conn := c.conn
+	altConn := c.altConn
+	c.conn = nil
+	c.altConn = nil
+	c.mtx.Unlock()
+	close(c.closed)
+	if altConn != nil {
+		altConn.Close()
+	}
+	if conn != nil {
+		// Unblocks ReadFromUDP
+		conn.Close()
+		c.save()
+	}
+}
+
+func (c *Udr) load() {
+	bs, err := os.ReadFile(c.fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Println("UDR load error:", err)
+		}
+		return
+	}
+	var state UdrState
+	err = json.Unmarshal(bs, &state)
+	if err != nil {
+		logger.Println("UDR load error:", err)
+		return
+	}
+	now := time.Now()
+	c.mtx.Lock()
+	for _, item := range state.Items {
+		if now.Before(item.Expires) {
+			c.db[item.XchgAddress] = item
+		}
+	}
+	logger.Println("UDR loaded", len(c.db), "records")
+	c.mtx.Unlock()
+}
+
+func (c *Udr) save() {
+	c.mtx.Lock()
+	if !c.changed {
+		c.mtx.Unlock()
+		return
+	}
+	c.changed = false
+	c.mtx.Unlock()
+
+	bs := []byte(c.State())
+	tmpFileName := c.fileName + ".tmp"
+	err := os.WriteFile(tmpFileName, bs, 0644)
+	if err == nil {
+		err = os.Rename(tmpFileName, c.fileName)
+	}
+	if err != nil {
+		logger.Println("UDR save error:", err)
+	}
+}
+
+func (c *Udr) thSave() {
+	for {
+		time.Sleep(UDR_SAVE_PERIOD)
+		c.mtx.Lock()
+		stopping := c.stopping
+		now := time.Now()
+		for key, item := range c.db {
+			if now.After(item.Expires) {
+				delete(c.db, key)
+				c.changed = true
+			}
+		}
+		connectInterval := time.Duration(c.config.ConnectIntervalMs) * time.Millisecond
+		for key, dt := range c.lastConnect {
+			if now.Sub(dt) >= connectInterval {
+				delete(c.lastConnect, key)
+			}
+		}
+		for _, session := range c.stunSessions {
+			if now.Sub(session.created) > STUN_SESSION_LIFETIME {
+				c.deleteStunSession(session)
+			}
+		}
+		c.clearRelay(now)
+		c.mtx.Unlock()
+		c.ipLimiter.Clear(now)
+		if stopping {
+			return
+		}
+		c.save()
+	}
 }
 
 func (c *Udr) State() string {
@@ -51,10 +324,7 @@ func (c *Udr) State() string {
 	})
 	var state UdrState
 	for _, a := range addrs {
-		var item UdrRecord
-		item.XchgAddress = a
-		item.IpPoint = c.db[a]
-		state.Items = append(state.Items, item)
+		state.Items = append(state.Items, c.db[a])
 	}
 	result, _ := json.MarshalIndent(state, "", " ")
 	return string(result)
@@ -64,43 +334,296 @@ func (c *Udr) GetIPByXchgAddress(xchgAddress string) string {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
-	ip, ok := c.db[xchgAddress]
-	if ok {
-		return ip
-	}
-	ip, ok = c.db["#"+xchgAddress]
-	if ok {
-		return ip
+	now := time.Now()
+	for _, key := range []string{xchgAddress, "#" + xchgAddress} {
+		item, ok := c.db[key]
+		if ok && now.Before(item.Expires) {
+			return item.IpPoint
+		}
 	}
 	return ""
 }
 
-func (c *Udr) th() {
-	logger.Println("UDR started")
-	addr, _ := net.ResolveUDPAddr("udp", ":8084")
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		logger.Println("ERROR:", err)
-		return
-	}
+func (c *Udr) Counters() UdrCounters {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	counters := c.counters
+	counters.Subscriptions = len(c.subscriptions)
+	counters.RelayActive = len(c.relayChannels)
+	return counters
+}
 
-	for !c.stopping {
-		buffer := make([]byte, 1024)
-		logger.Println("UDR reading ...", addr)
+func (c *Udr) th(conn *net.UDPConn, alt bool) {
+	logger.Println("UDR started", conn.LocalAddr())
+	buffer := make([]byte, UDR_READ_BUFFER_SIZE)
+	for {
 		bytesRead, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
-			logger.Println("ReadFromUDP error:", err)
+			c.mtx.Lock()
+			stopping := c.stopping
+			c.mtx.Unlock()
+			if !stopping {
+				logger.Println("ReadFromUDP error:", err)
+			}
 			break
 		}
-		incoming := string(buffer[0:bytesRead])
+		if bytesRead < 1 {
+			continue
+		}
+		// The buffer is reused, frames of the packet may be stored
+		packet := make([]byte, bytesRead)
+		copy(packet, buffer)
+
+		if packet[0] != UDR_PACKET_RELAY_DATA && !c.allowIp(remoteAddr) {
+			continue
+		}
+		if isStunPacket(packet) {
+			response, fromAlt := c.processStun(packet, remoteAddr, alt, time.Now())
+			if response != nil {
+				c.writeStun(response, remoteAddr, alt || fromAlt)
+			}
+			continue
+		}
+		if alt {
+			continue
+		}
+
+		var response []byte
+		switch packet[0] {
+		case UDR_PACKET_REGISTER:
+			response = c.processRegister(packet, remoteAddr, time.Now())
+		case UDR_PACKET_CONNECT:
+			response = c.processConnect(conn, packet, remoteAddr, time.Now())
+		case UDR_PACKET_SUBSCRIBE:
+			response = c.processSubscribe(packet, remoteAddr, time.Now())
+		case UDR_PACKET_FRAMES, UDR_PACKET_FRAMES_POW:
+			response = c.processFrames(packet, remoteAddr)
+		case UDR_PACKET_RELAY_ALLOCATE:
+			response = c.processRelayAllocate(conn, packet, remoteAddr, time.Now())
+		case UDR_PACKET_RELAY_DATA:
+			response = c.processRelayData(conn, packet, remoteAddr, time.Now())
+		}
+		if response != nil {
+			_, _ = conn.WriteToUDP(response, remoteAddr)
+		}
+	}
+	logger.Println("UDR stopped")
+}
+
+func (c *Udr) allowIp(remoteAddr *net.UDPAddr) bool {
+	err := c.ipLimiter.Allow(remoteAddr.IP.String(), 1, time.Now())
+	if err != nil {
 		c.mtx.Lock()
-		c.db[incoming] = remoteAddr.String()
+		c.counters.RateLimitedIp++
 		c.mtx.Unlock()
-		logger.Println("RECEIVED UDP", incoming, "from", remoteAddr.String())
 	}
+	return err == nil
+}
 
-	if conn != nil {
-		conn.Close()
+// mayReply reports whether the status of a signed request is sent to its source.
+// Requests without a valid signature get no reply. A replayed request gets UDR_STATUS_STALE,
+// it is sent only to the registered endpoint of the address: the source may be spoofed.
+func (c *Udr) mayReply(status byte, packet []byte, remoteAddr *net.UDPAddr) bool {
+	switch status {
+	case UDR_STATUS_MALFORMED, UDR_STATUS_BAD_SIGN:
+		return false
+	case UDR_STATUS_STALE:
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		record, exists := c.db[addressKey(packet[1:1+AddressBytesSize])]
+		return exists && record.IpPoint == remoteAddr.String()
 	}
+	return true
+}
+
+func (c *Udr) processRegister(packet []byte, remoteAddr *net.UDPAddr, now time.Time) []byte {
+	status := c.register(packet, remoteAddr, now)
+	if !c.mayReply(status, packet, remoteAddr) {
+		return nil
+	}
+	ack := make([]byte, UDR_ACK_SIZE)
+	ack[0] = UDR_PACKET_REGISTER_ACK
+	ack[1] = status
+	copy(ack[2:], packet[1:UDR_REGISTER_SIGNED])
+	if status == UDR_STATUS_OK {
+		binary.LittleEndian.PutUint32(ack[2+AddressBytesSize+8:], uint32(c.config.TtlMs))
+	}
+	return ack
+}
+
+func (c *Udr) register(packet []byte, remoteAddr *net.UDPAddr, now time.Time) byte {
+	status := c.verifySigned(packet, UDR_REGISTER_SIGNED, now)
+	if status != UDR_STATUS_OK {
+		c.mtx.Lock()
+		c.counters.Rejected++
+		c.mtx.Unlock()
+		return status
+	}
+
+	address := addressKey(packet[1 : 1+AddressBytesSize])
+	timestamp := int64(binary.LittleEndian.Uint64(packet[1+AddressBytesSize:]))
 
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	prev, exists := c.db[address]
+	if exists && timestamp <= prev.Timestamp {
+		c.counters.Rejected++
+		return UDR_STATUS_STALE
+	}
+	if !exists && len(c.db) >= c.config.MaxEntries {
+		for key, item := range c.db {
+			if now.After(item.Expires) {
+				delete(c.db, key)
+			}
+		}
+		if len(c.db) >= c.config.MaxEntries {
+			c.counters.Rejected++
+			return UDR_STATUS_TABLE_FULL
+		}
+	}
+	record := UdrRecord{
+		XchgAddress: address,
+		IpPoint:     remoteAddr.String(),
+		Timestamp:   timestamp,
+		Expires:     now.Add(time.Duration(c.config.TtlMs) * time.Millisecond),
+	}
+	// The NAT type is kept while the endpoint does not change
+	if exists && prev.IpPoint == record.IpPoint {
+		record.NatType = prev.NatType
+		record.NatUpdated = prev.NatUpdated
+	}
+	c.db[address] = record
+	c.changed = true
+	c.counters.Registered++
+	return UDR_STATUS_OK
+}
+
+func (c *Udr) processConnect(conn *net.UDPConn, packet []byte, remoteAddr *net.UDPAddr, now time.Time) []byte {
+	status, peerEndpoint := c.connect(packet, remoteAddr, now)
+	if !c.mayReply(status, packet, remoteAddr) {
+		return nil
+	}
+	ack := make([]byte, UDR_CONNECT_ACK_SIZE)
+	ack[0] = UDR_PACKET_CONNECT_ACK
+	ack[1] = status
+	copy(ack[2:], packet[1+AddressBytesSize:UDR_CONNECT_SIGNED])
+	if status != UDR_STATUS_OK {
+		return ack
+	}
+
+	// Both sides get the endpoint of each other at the same moment
+	timestamp := packet[UDR_CONNECT_SIGNED-8 : UDR_CONNECT_SIGNED]
+	toPeer := udrPeerPacket(packet[1:1+AddressBytesSize], remoteAddr, timestamp)
+	toRequester := udrPeerPacket(packet[1+AddressBytesSize:UDR_CONNECT_SIGNED-8], peerEndpoint, timestamp)
+	_, _ = conn.WriteToUDP(toPeer, peerEndpoint)
+	_, _ = conn.WriteToUDP(toRequester, remoteAddr)
+	return ack
+}
+
+func (c *Udr) connect(packet []byte, remoteAddr *net.UDPAddr, now time.Time) (byte, *net.UDPAddr) {
+	c.mtx.Lock()
+	c.counters.RendezvousRequests++
+	c.mtx.Unlock()
+
+	status := c.verifySigned(packet, UDR_CONNECT_SIGNED, now)
+	if status != UDR_STATUS_OK {
+		c.mtx.Lock()
+		c.counters.RendezvousRejected++
+		c.mtx.Unlock()
+		return status, nil
+	}
+
+	address := addressKey(packet[1 : 1+AddressBytesSize])
+	peerAddress := addressKey(packet[1+AddressBytesSize : 1+AddressBytesSize+AddressBytesSize])
+	timestamp := int64(binary.LittleEndian.Uint64(packet[UDR_CONNECT_SIGNED-8:]))
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if dt, ok := c.lastConnect[address]; ok && now.Sub(dt) < time.Duration(c.config.ConnectIntervalMs)*time.Millisecond {
+		c.counters.RendezvousRejected++
+		return UDR_STATUS_RATE_LIMIT, nil
+	}
+	status = c.refreshRecord(address, timestamp, remoteAddr, now)
+	if status != UDR_STATUS_OK {
+		c.counters.RendezvousRejected++
+		return status, nil
+	}
+	c.lastConnect[address] = now
+
+	peer, exists := c.db[peerAddress]
+	if !exists || now.After(peer.Expires) {
+		c.counters.RendezvousNoPeer++
+		return UDR_STATUS_NO_PEER, nil
+	}
+	peerEndpoint, err := net.ResolveUDPAddr("udp", peer.IpPoint)
+	if err != nil {
+		c.counters.RendezvousNoPeer++
+		return UDR_STATUS_NO_PEER, nil
+	}
+	c.counters.RendezvousCompleted++
+	return UDR_STATUS_OK, peerEndpoint
+}
+
+// refreshRecord accepts a signed request of a registered address:
+// the timestamp must grow, the source of the request becomes the endpoint.
+// c.mtx must be held.
+func (c *Udr) refreshRecord(address string, timestamp int64, remoteAddr *net.UDPAddr, now time.Time) byte {
+	record, exists := c.db[address]
+	if !exists || now.After(record.Expires) {
+		return UDR_STATUS_NOT_REG
+	}
+	if timestamp <= record.Timestamp {
+		return UDR_STATUS_STALE
+	}
+	record.Timestamp = timestamp
+	if record.IpPoint != remoteAddr.String() {
+		record.IpPoint = remoteAddr.String()
+		record.NatType = ""
+		record.NatUpdated = time.Time{}
+	}
+	c.db[address] = record
+	c.changed = true
+	return UDR_STATUS_OK
+}
+
+func udrPeerPacket(peerAddress []byte, endpoint *net.UDPAddr, timestamp []byte) []byte {
+	packet := make([]byte, UDR_PEER_SIZE)
+	packet[0] = UDR_PACKET_PEER
+	copy(packet[1:], peerAddress)
+	copy(packet[1+AddressBytesSize:], endpoint.IP.To16())
+	binary.LittleEndian.PutUint16(packet[1+AddressBytesSize+net.IPv6len:], uint16(endpoint.Port))
+	copy(packet[1+AddressBytesSize+net.IPv6len+2:], timestamp)
+	return packet
+}
+
+// verifySigned checks a packet signed by the key of its first address:
+// [signed part: [type][address 30]...[timestamp 8]][uint16 pubLen][public key][signature]
+// The timestamp is checked after the signature, see mayReply for the replies.
+func (c *Udr) verifySigned(packet []byte, signedSize int, now time.Time) byte {
+	if len(packet) < signedSize+2 {
+		return UDR_STATUS_MALFORMED
+	}
+	addressBS := packet[1 : 1+AddressBytesSize]
+	timestamp := int64(binary.LittleEndian.Uint64(packet[signedSize-8:]))
+	pubLen := int(binary.LittleEndian.Uint16(packet[signedSize:]))
+	if len(packet) < signedSize+2+pubLen {
+		return UDR_STATUS_MALFORMED
+	}
+	publicKeyDer := packet[signedSize+2 : signedSize+2+pubLen]
+	signature := packet[signedSize+2+pubLen:]
+
+	if !bytes.Equal(addressOfPublicKey(publicKeyDer), addressBS) {
+		return UDR_STATUS_BAD_SIGN
+	}
+	if verifySignature(publicKeyDer, packet[:signedSize], signature) != nil {
+		return UDR_STATUS_BAD_SIGN
+	}
+	skew := now.UnixMilli() - timestamp
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > int64(c.config.MaxClockSkewMs) {
+		return UDR_STATUS_STALE
+	}
+	return UDR_STATUS_OK
 }
@@ -0,0 +1,215 @@
+package xchgr_server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+//////////////////////////////////////////////////////
+// Frame delivery over the UDR socket
+// There is no fragmentation: a datagram carries whole frames only
+// and must not exceed max_datagram_size (see UdrConfig).
+// UDR_PACKET_SUBSCRIBE (client -> router):
+//   [address 30][afterId 8][timestamp 8][uint16 pubLen][public key][signature]
+//   signature  - RSA PKCS #1 v1.5 over SHA256([type][address][afterId][timestamp])
+//   The address must be registered, the timestamp and the signature follow
+//   the rules of UDR_PACKET_REGISTER. Frames stored for the address after afterId are
+//   pushed to its endpoint until the registration expires. A repeated
+//   subscription restarts the push after the new afterId.
+// UDR_PACKET_SUBSCRIBE_ACK (router -> client):
+//   [status 1][address 30][timestamp 8]
+// UDR_PACKET_FRAMES (client -> router):
+//   the same as the /api/w request
+// UDR_PACKET_FRAMES_POW (client -> router):
+//   [uint16 powLen][PoW][frames], see pow.go
+// UDR_PACKET_ERROR (router -> client):
+//   the error text, sent only when frames are not accepted and only if
+//   the source of the datagram is the registered endpoint of the source
+//   address of the first frame; other senders get no reply.
+// The datagrams count in the per_ip limit of UDR (not of HTTP).
+// UDR_PACKET_PUSH (router -> client):
+//   [flags 1][lastId 8][frames]
+//   lastId is the afterId for the next subscription or /api/r request.
+//   Frames that do not fit into a datagram are not pushed,
+//   UDR_PUSH_FLAG_SKIPPED is set and they must be read with /api/r.
+//   Pushes are not acknowledged; after a loss the client subscribes again
+//   with the last received lastId.
+//////////////////////////////////////////////////////
+
+const (
+	UDR_PACKET_SUBSCRIBE     = byte(0x06)
+	UDR_PACKET_SUBSCRIBE_ACK = byte(0x07)
+	UDR_PACKET_FRAMES        = byte(0x08)
+	UDR_PACKET_FRAMES_POW    = byte(0x09)
+	UDR_PACKET_ERROR         = byte(0x0A)
+	UDR_PACKET_PUSH          = byte(0x0B)
+
+	UDR_PUSH_FLAG_SKIPPED = byte(0x01)
+
+	UDR_SUBSCRIBE_SIGNED   = 1 + AddressBytesSize + 8 + 8
+	UDR_SUBSCRIBE_ACK_SIZE = 1 + 1 + AddressBytesSize + 8
+	UDR_PUSH_HEADER        = 1 + 1 + 8
+	UDR_MAX_DATAGRAM_SIZE  = 65507
+	UDR_PUSH_CHECK_PERIOD  = 1 * time.Second
+)
+
+type udrSubscription struct {
+	afterId uint64
+	changed chan struct{}
+}
+
+func (c *Udr) processSubscribe(packet []byte, remoteAddr *net.UDPAddr, now time.Time) []byte {
+	status := c.subscribe(packet, remoteAddr, now)
+	if !c.mayReply(status, packet, remoteAddr) {
+		return nil
+	}
+	ack := make([]byte, UDR_SUBSCRIBE_ACK_SIZE)
+	ack[0] = UDR_PACKET_SUBSCRIBE_ACK
+	ack[1] = status
+	copy(ack[2:], packet[1:1+AddressBytesSize])
+	copy(ack[2+AddressBytesSize:], packet[UDR_SUBSCRIBE_SIGNED-8:UDR_SUBSCRIBE_SIGNED])
+	return ack
+}
+
+func (c *Udr) subscribe(packet []byte, remoteAddr *net.UDPAddr, now time.Time) byte {
+	status := c.verifySigned(packet, UDR_SUBSCRIBE_SIGNED, now)
+	if status != UDR_STATUS_OK {
+		return status
+	}
+
+	address := addressKey(packet[1 : 1+AddressBytesSize])
+	afterId := binary.LittleEndian.Uint64(packet[1+AddressBytesSize:])
+	timestamp := int64(binary.LittleEndian.Uint64(packet[UDR_SUBSCRIBE_SIGNED-8:]))
+
+	c.mtx.Lock()
+	status = c.refreshRecord(address, timestamp, remoteAddr, now)
+	sub, exists := c.subscriptions[address]
+	if status == UDR_STATUS_OK && exists {
+		sub.afterId = afterId
+		select {
+		case sub.changed <- struct{}{}:
+		default:
+		}
+	}
+	c.mtx.Unlock()
+	if status != UDR_STATUS_OK || exists {
+		return status
+	}
+
+	// The signature proves the ownership of the address like /api/auth does
+	addressStorage, listener, err := c.router.SubscribeAddress(address)
+	if err != nil {
+		return UDR_STATUS_RATE_LIMIT
+	}
+	sub = &udrSubscription{afterId: afterId, changed: make(chan struct{}, 1)}
+	c.mtx.Lock()
+	c.subscriptions[address] = sub
+	c.mtx.Unlock()
+	go c.thPush(address, sub, addressStorage, listener)
+	return UDR_STATUS_OK
+}
+
+func (c *Udr) thPush(address string, sub *udrSubscription, addressStorage *AddressStorage, listener chan struct{}) {
+	defer c.router.Unsubscribe(addressStorage, listener)
+	ticker := time.NewTicker(UDR_PUSH_CHECK_PERIOD)
+	defer ticker.Stop()
+	maxSize := c.config.MaxDatagramSize - UDR_PUSH_HEADER
+
+	for {
+		c.mtx.Lock()
+		record, ok := c.db[address]
+		conn := c.conn
+		afterId := sub.afterId
+		if !ok || time.Now().After(record.Expires) || conn == nil {
+			if c.subscriptions[address] == sub {
+				delete(c.subscriptions, address)
+			}
+			c.mtx.Unlock()
+			return
+		}
+		c.mtx.Unlock()
+
+		data, lastId, count, skipped := c.router.GetMessagesLimited(address, afterId, maxSize)
+		if count > 0 || skipped > 0 {
+			packet := make([]byte, UDR_PUSH_HEADER, UDR_PUSH_HEADER+len(data))
+			packet[0] = UDR_PACKET_PUSH
+			if skipped > 0 {
+				packet[1] |= UDR_PUSH_FLAG_SKIPPED
+			}
+			binary.LittleEndian.PutUint64(packet[2:], lastId)
+			packet = append(packet, data...)
+			endpoint, err := net.ResolveUDPAddr("udp", record.IpPoint)
+			if err == nil {
+				_, _ = conn.WriteToUDP(packet, endpoint)
+			}
+
+			c.mtx.Lock()
+			// A new subscription replaces the position
+			if sub.afterId == afterId {
+				sub.afterId = lastId
+			}
+			c.counters.Pushed++
+			c.counters.PushSkipped += skipped
+			c.mtx.Unlock()
+			continue
+		}
+
+		select {
+		case <-listener:
+		case <-sub.changed:
+		case <-ticker.C:
+		case <-c.closed:
+		}
+	}
+}
+
+func (c *Udr) processFrames(packet []byte, remoteAddr *net.UDPAddr) []byte {
+	frames, err := c.putFrames(packet)
+	c.mtx.Lock()
+	if err != nil {
+		c.counters.FramesRejected++
+	} else {
+		c.counters.FramesReceived++
+	}
+	c.mtx.Unlock()
+	if err != nil && c.isSenderEndpoint(frames, remoteAddr) {
+		return append([]byte{UDR_PACKET_ERROR}, []byte(err.Error())...)
+	}
+	return nil
+}
+
+// putFrames returns the frames of the datagram (nil if they can not be found)
+func (c *Udr) putFrames(packet []byte) ([]byte, error) {
+	frames := packet[1:]
+	var pow []byte
+	if packet[0] == UDR_PACKET_FRAMES_POW {
+		if len(packet) < 1+2 {
+			return nil, fmt.Errorf("%w: truncated PoW length", ErrMalformedFrame)
+		}
+		powLen := int(binary.LittleEndian.Uint16(packet[1:]))
+		if len(packet) < 1+2+powLen {
+			return nil, fmt.Errorf("%w: truncated PoW", ErrMalformedFrame)
+		}
+		pow = packet[1+2 : 1+2+powLen]
+		frames = packet[1+2+powLen:]
+	}
+	if len(packet) > c.config.MaxDatagramSize {
+		return frames, fmt.Errorf("datagram size %d exceeds %d", len(packet), c.config.MaxDatagramSize)
+	}
+	return frames, c.router.PutFrames(frames, pow)
+}
+
+// isSenderEndpoint reports whether remoteAddr is the registered endpoint
+// of the source address of the first frame
+func (c *Udr) isSenderEndpoint(frames []byte, remoteAddr *net.UDPAddr) bool {
+	frame, _, err := NextFrame(frames)
+	if err != nil {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	record, exists := c.db[frame.SrcAddressString()]
+	return exists && time.Now().Before(record.Expires) && record.IpPoint == remoteAddr.String()
+}
@@ -0,0 +1,278 @@
+package xchgr_server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+)
+
+//////////////////////////////////////////////////////
+// UDP relay between registered peers (the fallback when hole punching fails)
+// UDR_PACKET_RELAY_ALLOCATE (A -> router):
+//   [address A 30][address B 30][timestamp 8][uint16 pubLen][public key A][signature]
+//   signature  - RSA PKCS #1 v1.5 over SHA256([type][address A][address B][timestamp])
+//   Both addresses must be registered, the timestamp follows the rules of
+//   UDR_PACKET_REGISTER. The allocation is active when both A and B have
+//   asked for it; the source of the request is the relay endpoint of the side.
+//   No reply if the relay is disabled or the signature is not valid.
+// UDR_PACKET_RELAY_ACK (router -> peer):
+//   [status 1][peer address 30][timestamp 8][channel 8][uint32 bytes per second][uint32 idle timeout ms]
+//   status UDR_STATUS_PENDING - waiting for the peer. When the peer allocates,
+//   both sides get UDR_STATUS_OK.
+// UDR_PACKET_RELAY_DATA (peer -> router -> peer):
+//   [channel 8][payload]
+//   Accepted only from the relay endpoints of the allocation and forwarded
+//   unchanged to the other one. Datagrams over max_datagram_size or over the
+//   bandwidth of the allocation are dropped. Every forwarded datagram is counted
+//   as a frame of the sender in its billing period; when the limit is reached
+//   the sender gets UDR_PACKET_ERROR. The allocation is removed after
+//   idle_timeout_ms without data, datagrams of an unknown channel are dropped.
+//////////////////////////////////////////////////////
+
+const (
+	UDR_PACKET_RELAY_ALLOCATE = byte(0x0C)
+	UDR_PACKET_RELAY_ACK      = byte(0x0D)
+	UDR_PACKET_RELAY_DATA     = byte(0x0E)
+
+	UDR_STATUS_PENDING = byte(0x08)
+
+	UDR_RELAY_SIGNED     = 1 + AddressBytesSize + AddressBytesSize + 8
+	UDR_RELAY_ACK_SIZE   = 1 + 1 + AddressBytesSize + 8 + 8 + 4 + 4
+	UDR_RELAY_DATA_INDEX = 1 + 8
+)
+
+type UdrRelayConfig struct {
+	Enabled                 bool `json:"enabled"`
+	BandwidthBytesPerSecond int  `json:"bandwidth_bytes_per_second"`
+	BurstBytes              int  `json:"burst_bytes"`
+	IdleTimeoutMs           int  `json:"idle_timeout_ms"`
+	MaxAllocations          int  `json:"max_allocations"`
+	// Allocations one address may ask for, pending or active
+	MaxAllocationsPerAddress int `json:"max_allocations_per_address"`
+}
+
+type udrAllocation struct {
+	channel    uint64
+	addresses  [2]string
+	endpoints  [2]*net.UDPAddr
+	timestamps [2]int64
+	active     bool
+	lastDT     time.Time
+}
+
+func relayPairKey(address1 string, address2 string) string {
+	if address1 > address2 {
+		address1, address2 = address2, address1
+	}
+	return address1 + "/" + address2
+}
+
+func (c *Udr) processRelayAllocate(conn *net.UDPConn, packet []byte, remoteAddr *net.UDPAddr, now time.Time) []byte {
+	if !c.config.Relay.Enabled {
+		return nil
+	}
+	var peerAddressBS []byte
+	var timestamp int64
+	if len(packet) >= UDR_RELAY_SIGNED {
+		peerAddressBS = packet[1+AddressBytesSize : 1+AddressBytesSize+AddressBytesSize]
+		timestamp = int64(binary.LittleEndian.Uint64(packet[UDR_RELAY_SIGNED-8:]))
+	}
+
+	status, allocation, notify := c.relayAllocate(packet, remoteAddr, now)
+	if !c.mayReply(status, packet, remoteAddr) {
+		return nil
+	}
+	if status != UDR_STATUS_OK && status != UDR_STATUS_PENDING {
+		return c.relayAck(status, peerAddressBS, timestamp, 0)
+	}
+	if notify != nil {
+		// The peer is waiting for the allocation
+		peerAddress := packet[1 : 1+AddressBytesSize]
+		_, _ = conn.WriteToUDP(c.relayAck(UDR_STATUS_OK, peerAddress, allocation.timestamps[notify.side], allocation.channel), notify.endpoint)
+	}
+	return c.relayAck(status, peerAddressBS, timestamp, allocation.channel)
+}
+
+type relayNotify struct {
+	side     int
+	endpoint *net.UDPAddr
+}
+
+func (c *Udr) relayAllocate(packet []byte, remoteAddr *net.UDPAddr, now time.Time) (status byte, allocation udrAllocation, notify *relayNotify) {
+	status = c.verifySigned(packet, UDR_RELAY_SIGNED, now)
+	if status != UDR_STATUS_OK {
+		return status, allocation, nil
+	}
+
+	address := addressKey(packet[1 : 1+AddressBytesSize])
+	peerAddress := addressKey(packet[1+AddressBytesSize : 1+AddressBytesSize+AddressBytesSize])
+	timestamp := int64(binary.LittleEndian.Uint64(packet[UDR_RELAY_SIGNED-8:]))
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	status = c.refreshRecord(address, timestamp, remoteAddr, now)
+	if status != UDR_STATUS_OK {
+		return status, allocation, nil
+	}
+	peer, exists := c.db[peerAddress]
+	if !exists || now.After(peer.Expires) || peerAddress == address {
+		return UDR_STATUS_NO_PEER, allocation, nil
+	}
+
+	key := relayPairKey(address, peerAddress)
+	a, exists := c.relayPairs[key]
+	if exists && c.relayIdle(a, now) {
+		c.removeAllocation(a)
+		exists = false
+	}
+	side := 0
+	if exists && a.addresses[1] == address {
+		side = 1
+	}
+	asked := exists && a.endpoints[side] != nil
+	if !asked && c.relayAsked[address] >= c.config.Relay.MaxAllocationsPerAddress {
+		return UDR_STATUS_TABLE_FULL, allocation, nil
+	}
+	if !exists {
+		if len(c.relayPairs) >= c.config.Relay.MaxAllocations {
+			return UDR_STATUS_TABLE_FULL, allocation, nil
+		}
+		a = &udrAllocation{channel: c.newRelayChannel()}
+		a.addresses[0] = address
+		a.addresses[1] = peerAddress
+		c.relayPairs[key] = a
+		c.relayChannels[a.channel] = a
+		c.counters.RelayAllocated++
+	}
+
+	if !asked {
+		c.relayAsked[address]++
+	}
+	a.endpoints[side] = remoteAddr
+	a.timestamps[side] = timestamp
+	a.lastDT = now
+	status = UDR_STATUS_PENDING
+	if a.endpoints[1-side] != nil {
+		status = UDR_STATUS_OK
+		if !a.active {
+			a.active = true
+			notify = &relayNotify{side: 1 - side, endpoint: a.endpoints[1-side]}
+		}
+	}
+	return status, *a, notify
+}
+
+func (c *Udr) processRelayData(conn *net.UDPConn, packet []byte, remoteAddr *net.UDPAddr, now time.Time) []byte {
+	if len(packet) < UDR_RELAY_DATA_INDEX {
+		return nil
+	}
+	channel := binary.LittleEndian.Uint64(packet[1:])
+
+	c.mtx.Lock()
+	a, ok := c.relayChannels[channel]
+	if ok && c.relayIdle(a, now) {
+		c.removeAllocation(a)
+		ok = false
+	}
+	if !ok {
+		c.counters.RelayDropped++
+		c.mtx.Unlock()
+		return nil
+	}
+	side := -1
+	for i, endpoint := range a.endpoints {
+		if endpoint != nil && endpoint.String() == remoteAddr.String() {
+			side = i
+		}
+	}
+	if side < 0 || !a.active || len(packet) > c.config.MaxDatagramSize {
+		c.counters.RelayDropped++
+		c.mtx.Unlock()
+		return nil
+	}
+	sender := a.addresses[side]
+	endpoint := a.endpoints[1-side]
+	c.mtx.Unlock()
+
+	payloadSize := len(packet) - UDR_RELAY_DATA_INDEX
+	if c.relayLimiter.Allow(strconv.FormatUint(channel, 16), payloadSize, now) != nil {
+		c.mtx.Lock()
+		c.counters.RelayDropped++
+		c.mtx.Unlock()
+		return nil
+	}
+	err := c.router.ChargeFrame(sender)
+	if err != nil {
+		c.mtx.Lock()
+		c.counters.RelayDropped++
+		c.mtx.Unlock()
+		return append([]byte{UDR_PACKET_ERROR}, []byte(err.Error())...)
+	}
+
+	_, _ = conn.WriteToUDP(packet, endpoint)
+	c.mtx.Lock()
+	a.lastDT = now
+	c.counters.RelayDatagrams++
+	c.counters.RelayBytes += payloadSize
+	c.mtx.Unlock()
+	return nil
+}
+
+func (c *Udr) relayAck(status byte, peerAddress []byte, timestamp int64, channel uint64) []byte {
+	ack := make([]byte, UDR_RELAY_ACK_SIZE)
+	ack[0] = UDR_PACKET_RELAY_ACK
+	ack[1] = status
+	copy(ack[2:], peerAddress)
+	binary.LittleEndian.PutUint64(ack[2+AddressBytesSize:], uint64(timestamp))
+	if status == UDR_STATUS_OK || status == UDR_STATUS_PENDING {
+		binary.LittleEndian.PutUint64(ack[2+AddressBytesSize+8:], channel)
+		binary.LittleEndian.PutUint32(ack[2+AddressBytesSize+8+8:], uint32(c.config.Relay.BandwidthBytesPerSecond))
+		binary.LittleEndian.PutUint32(ack[2+AddressBytesSize+8+8+4:], uint32(c.config.Relay.IdleTimeoutMs))
+	}
+	return ack
+}
+
+// c.mtx must be held
+func (c *Udr) newRelayChannel() uint64 {
+	for {
+		bs := make([]byte, 8)
+		_, _ = rand.Read(bs)
+		channel := binary.LittleEndian.Uint64(bs)
+		if _, exists := c.relayChannels[channel]; !exists && channel != 0 {
+			return channel
+		}
+	}
+}
+
+// c.mtx must be held
+func (c *Udr) relayIdle(a *udrAllocation, now time.Time) bool {
+	return now.Sub(a.lastDT) > time.Duration(c.config.Relay.IdleTimeoutMs)*time.Millisecond
+}
+
+// c.mtx must be held
+func (c *Udr) removeAllocation(a *udrAllocation) {
+	delete(c.relayChannels, a.channel)
+	delete(c.relayPairs, relayPairKey(a.addresses[0], a.addresses[1]))
+	for side, endpoint := range a.endpoints {
+		if endpoint == nil {
+			continue
+		}
+		address := a.addresses[side]
+		c.relayAsked[address]--
+		if c.relayAsked[address] <= 0 {
+			delete(c.relayAsked, address)
+		}
+	}
+}
+
+// c.mtx must be held
+func (c *Udr) clearRelay(now time.Time) {
+	for _, a := range c.relayChannels {
+		if c.relayIdle(a, now) {
+			c.removeAllocation(a)
+		}
+	}
+	c.relayLimiter.Clear(now)
+}
@@ -0,0 +1,395 @@
+package xchgr_server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////
+// STUN (RFC 5389) on the UDR sockets
+// Packets with the magic cookie at [4:8] are STUN messages,
+// UDR packet types never start with 0x00.
+// Binding request -> Binding success response with
+//   XOR-MAPPED-ADDRESS, MAPPED-ADDRESS and OTHER-ADDRESS
+//   (the alternate port, the IP is unspecified if the router
+//   listens on all interfaces - use the IP of the router).
+// CHANGE-REQUEST with the change port flag is answered from the
+// alternate port. The router has a single IP, change IP gets 420.
+//
+// NAT classification, USERNAME is the xchg address of the client:
+// 1. Binding request to the main port. The response carries XCHG-NAT-NONCE,
+//    the test session is kept for the username and the mapping of the request.
+// 2. Binding request to the main port with XCHG-NAT-NONCE and
+//    CHANGE-REQUEST (change port). The response comes from the alternate
+//    port and carries XCHG-NAT-PROBE.
+// 3. Binding request to the alternate port with XCHG-NAT-NONCE and
+//    XCHG-NAT-PROBE if the second response was received.
+//    The response carries XCHG-NAT-TYPE:
+//    symmetric  - the requests got different mappings
+//    full_cone  - the response from the not yet contacted port passed the NAT
+//    restricted - it did not
+// The nonce is only sent to the mapping of the first request, a request
+// without it does not finish the test.
+// With a single router IP address restricted and full cone NATs that
+// filter by IP only both look like full_cone.
+// If the address is registered in UDR from the mapping of the first request,
+// the result is kept in its record and served by /api/nat.
+//////////////////////////////////////////////////////
+
+const (
+	STUN_MAGIC_COOKIE = uint32(0x2112A442)
+	STUN_HEADER_SIZE  = 20
+
+	STUN_BINDING_REQUEST  = uint16(0x0001)
+	STUN_BINDING_SUCCESS  = uint16(0x0101)
+	STUN_BINDING_ERROR    = uint16(0x0111)
+	STUN_ATTR_MAPPED      = uint16(0x0001)
+	STUN_ATTR_CHANGE_REQ  = uint16(0x0003)
+	STUN_ATTR_USERNAME    = uint16(0x0006)
+	STUN_ATTR_INTEGRITY   = uint16(0x0008)
+	STUN_ATTR_ERROR_CODE  = uint16(0x0009)
+	STUN_ATTR_UNKNOWN     = uint16(0x000A)
+	STUN_ATTR_XOR_MAPPED  = uint16(0x0020)
+	STUN_ATTR_SOFTWARE    = uint16(0x8022)
+	STUN_ATTR_OTHER_ADDR  = uint16(0x802C)
+	STUN_ATTR_XCHG_NONCE  = uint16(0x8051)
+	STUN_ATTR_XCHG_NAT    = uint16(0x8052)
+	STUN_ATTR_XCHG_PROBE  = uint16(0x8053)
+	STUN_CHANGE_IP_FLAG   = uint32(0x04)
+	STUN_CHANGE_PORT_FLAG = uint32(0x02)
+
+	STUN_NAT_NONCE_SIZE    = 8
+	STUN_SESSION_LIFETIME  = 30 * time.Second
+	STUN_SOFTWARE          = "xchgr"
+	STUN_ERROR_BAD_REQUEST = 400
+	STUN_ERROR_UNKNOWN     = 420
+
+	NAT_TYPE_UNKNOWN    = "unknown"
+	NAT_TYPE_FULL_CONE  = "full_cone"
+	NAT_TYPE_RESTRICTED = "restricted"
+	NAT_TYPE_SYMMETRIC  = "symmetric"
+)
+
+// Values of XCHG-NAT-TYPE
+var natTypeCodes = map[string]byte{
+	NAT_TYPE_UNKNOWN:    0,
+	NAT_TYPE_FULL_CONE:  1,
+	NAT_TYPE_RESTRICTED: 2,
+	NAT_TYPE_SYMMETRIC:  3,
+}
+
+type stunSession struct {
+	username string
+	mapped   string
+	nonce    []byte
+	probe    []byte
+	created  time.Time
+}
+
+type stunAttr struct {
+	attrType uint16
+	value    []byte
+}
+
+type UdrNatInfo struct {
+	Address  string    `json:"address"`
+	Endpoint string    `json:"endpoint"`
+	NatType  string    `json:"nat_type"`
+	Updated  time.Time `json:"updated"`
+}
+
+func isStunPacket(packet []byte) bool {
+	return len(packet) >= STUN_HEADER_SIZE && packet[0] == 0x00 &&
+		binary.BigEndian.Uint32(packet[4:]) == STUN_MAGIC_COOKIE
+}
+
+// processStun returns the response and whether it must be sent from the alternate port
+func (c *Udr) processStun(packet []byte, remoteAddr *net.UDPAddr, alt bool, now time.Time) ([]byte, bool) {
+	if binary.BigEndian.Uint16(packet[0:]) != STUN_BINDING_REQUEST {
+		return nil, false
+	}
+	c.mtx.Lock()
+	c.counters.StunRequests++
+	c.mtx.Unlock()
+	txId := packet[8:STUN_HEADER_SIZE]
+
+	var username string
+	var nonce []byte
+	var probe []byte
+	var changeRequest uint32
+	var unknown []uint16
+	msgLen := int(binary.BigEndian.Uint16(packet[2:]))
+	if msgLen != len(packet)-STUN_HEADER_SIZE || msgLen%4 != 0 {
+		return c.stunError(txId, STUN_ERROR_BAD_REQUEST, "Bad Request", nil), false
+	}
+	for offset := STUN_HEADER_SIZE; offset < len(packet); {
+		if offset+4 > len(packet) {
+			return c.stunError(txId, STUN_ERROR_BAD_REQUEST, "Bad Request", nil), false
+		}
+		attrType := binary.BigEndian.Uint16(packet[offset:])
+		attrLen := int(binary.BigEndian.Uint16(packet[offset+2:]))
+		if offset+4+attrLen > len(packet) {
+			return c.stunError(txId, STUN_ERROR_BAD_REQUEST, "Bad Request", nil), false
+		}
+		value := packet[offset+4 : offset+4+attrLen]
+		switch attrType {
+		case STUN_ATTR_USERNAME:
+			username = string(value)
+		case STUN_ATTR_CHANGE_REQ:
+			if len(value) != 4 {
+				return c.stunError(txId, STUN_ERROR_BAD_REQUEST, "Bad Request", nil), false
+			}
+			changeRequest = binary.BigEndian.Uint32(value)
+		case STUN_ATTR_XCHG_NONCE:
+			nonce = value
+		case STUN_ATTR_XCHG_PROBE:
+			probe = value
+		case STUN_ATTR_INTEGRITY:
+			// No credentials on the router
+		default:
+			if attrType < 0x8000 {
+				unknown = append(unknown, attrType)
+			}
+		}
+		offset += 4 + (attrLen+3)/4*4
+	}
+
+	c.mtx.Lock()
+	altConn := c.altConn
+	c.mtx.Unlock()
+	if changeRequest&STUN_CHANGE_IP_FLAG != 0 || (changeRequest&STUN_CHANGE_PORT_FLAG != 0 && altConn == nil) {
+		unknown = append(unknown, STUN_ATTR_CHANGE_REQ)
+	}
+	if len(unknown) > 0 {
+		return c.stunError(txId, STUN_ERROR_UNKNOWN, "Unknown Attribute", unknown), false
+	}
+
+	attrs := []stunAttr{
+		{STUN_ATTR_XOR_MAPPED, stunXorAddress(remoteAddr, txId)},
+		{STUN_ATTR_MAPPED, stunAddress(remoteAddr.IP, remoteAddr.Port)},
+	}
+	if altConn != nil {
+		otherConn := altConn
+		if alt {
+			c.mtx.Lock()
+			otherConn = c.conn
+			c.mtx.Unlock()
+		}
+		if otherConn != nil {
+			otherAddr := otherConn.LocalAddr().(*net.UDPAddr)
+			attrs = append(attrs, stunAttr{STUN_ATTR_OTHER_ADDR, stunAddress(otherAddr.IP, otherAddr.Port)})
+		}
+	}
+
+	fromAlt := changeRequest&STUN_CHANGE_PORT_FLAG != 0 && !alt
+	if username != "" && altConn != nil {
+		switch {
+		case alt:
+			natType, ok := c.finishNatTest(username, remoteAddr, nonce, probe, now)
+			if ok {
+				value := make([]byte, 4)
+				value[0] = natTypeCodes[natType]
+				attrs = append(attrs, stunAttr{STUN_ATTR_XCHG_NAT, value})
+			}
+		case fromAlt:
+			if probe, ok := c.probeNatTest(username, remoteAddr, nonce, now); ok {
+				attrs = append(attrs, stunAttr{STUN_ATTR_XCHG_PROBE, probe})
+			}
+		default:
+			attrs = append(attrs, stunAttr{STUN_ATTR_XCHG_NONCE, c.startNatTest(username, remoteAddr, now)})
+		}
+	}
+	attrs = append(attrs, stunAttr{STUN_ATTR_SOFTWARE, []byte(STUN_SOFTWARE)})
+	return stunMessage(STUN_BINDING_SUCCESS, txId, attrs), fromAlt
+}
+
+func (c *Udr) writeStun(response []byte, remoteAddr *net.UDPAddr, alt bool) {
+	c.mtx.Lock()
+	conn := c.conn
+	if alt {
+		conn = c.altConn
+	}
+	c.mtx.Unlock()
+	if conn != nil {
+		_, _ = conn.WriteToUDP(response, remoteAddr)
+	}
+}
+
+func stunSessionKey(username string, mapped string) string {
+	return username + " " + mapped
+}
+
+func randomNatValue() []byte {
+	value := make([]byte, STUN_NAT_NONCE_SIZE)
+	_, _ = rand.Read(value)
+	return value
+}
+
+// startNatTest opens the session of the username and the mapping of the request
+func (c *Udr) startNatTest(username string, remoteAddr *net.UDPAddr, now time.Time) []byte {
+	nonce := randomNatValue()
+	key := stunSessionKey(username, remoteAddr.String())
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if session, exists := c.stunSessions[key]; exists {
+		c.deleteStunSession(session)
+	} else if len(c.stunSessions) >= c.config.MaxEntries {
+		return nonce
+	}
+	session := &stunSession{
+		username: username,
+		mapped:   remoteAddr.String(),
+		nonce:    nonce,
+		created:  now,
+	}
+	c.stunSessions[key] = session
+	c.stunNonces[string(nonce)] = session
+	return nonce
+}
+
+// probeNatTest gives the value sent from the alternate port, the request must come
+// from the mapping of the session with its nonce
+func (c *Udr) probeNatTest(username string, remoteAddr *net.UDPAddr, nonce []byte, now time.Time) ([]byte, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	session, ok := c.stunSessions[stunSessionKey(username, remoteAddr.String())]
+	if !ok || now.Sub(session.created) > STUN_SESSION_LIFETIME || string(nonce) != string(session.nonce) {
+		return nil, false
+	}
+	if session.probe == nil {
+		session.probe = randomNatValue()
+	}
+	return session.probe, true
+}
+
+// finishNatTest finds the session by the nonce, the request may come from another mapping
+func (c *Udr) finishNatTest(username string, remoteAddr *net.UDPAddr, nonce []byte, probe []byte, now time.Time) (natType string, ok bool) {
+	if len(nonce) != STUN_NAT_NONCE_SIZE {
+		return "", false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	session, ok := c.stunNonces[string(nonce)]
+	if !ok || session.username != username || now.Sub(session.created) > STUN_SESSION_LIFETIME {
+		return "", false
+	}
+	c.deleteStunSession(session)
+
+	switch {
+	case session.mapped != remoteAddr.String():
+		natType = NAT_TYPE_SYMMETRIC
+	case session.probe != nil && string(probe) == string(session.probe):
+		natType = NAT_TYPE_FULL_CONE
+	default:
+		natType = NAT_TYPE_RESTRICTED
+	}
+	c.counters.NatClassified++
+
+	address := username
+	if !strings.HasPrefix(address, "#") {
+		address = "#" + address
+	}
+	record, exists := c.db[strings.ToLower(address)]
+	if exists && record.IpPoint == session.mapped && now.Before(record.Expires) {
+		record.NatType = natType
+		record.NatUpdated = now
+		c.db[record.XchgAddress] = record
+		c.changed = true
+	}
+	return natType, true
+}
+
+func (c *Udr) deleteStunSession(session *stunSession) {
+	delete(c.stunSessions, stunSessionKey(session.username, session.mapped))
+	delete(c.stunNonces, string(session.nonce))
+}
+
+func (c *Udr) NatInfo(xchgAddress string) (info UdrNatInfo, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	for _, key := range []string{xchgAddress, "#" + xchgAddress} {
+		record, exists := c.db[strings.ToLower(key)]
+		if exists && now.Before(record.Expires) {
+			info.Address = record.XchgAddress
+			info.Endpoint = record.IpPoint
+			info.NatType = record.NatType
+			info.Updated = record.NatUpdated
+			if info.NatType == "" {
+				info.NatType = NAT_TYPE_UNKNOWN
+			}
+			return info, true
+		}
+	}
+	return info, false
+}
+
+func (c *Udr) stunError(txId []byte, code int, reason string, unknown []uint16) []byte {
+	c.mtx.Lock()
+	c.counters.StunErrors++
+	c.mtx.Unlock()
+
+	value := make([]byte, 4, 4+len(reason))
+	value[2] = byte(code / 100)
+	value[3] = byte(code % 100)
+	value = append(value, []byte(reason)...)
+	attrs := []stunAttr{{STUN_ATTR_ERROR_CODE, value}}
+	if len(unknown) > 0 {
+		list := make([]byte, 2*len(unknown))
+		for i, attrType := range unknown {
+			binary.BigEndian.PutUint16(list[2*i:], attrType)
+		}
+		attrs = append(attrs, stunAttr{STUN_ATTR_UNKNOWN, list})
+	}
+	return stunMessage(STUN_BINDING_ERROR, txId, attrs)
+}
+
+func stunMessage(msgType uint16, txId []byte, attrs []stunAttr) []byte {
+	msg := make([]byte, STUN_HEADER_SIZE)
+	binary.BigEndian.PutUint16(msg[0:], msgType)
+	binary.BigEndian.PutUint32(msg[4:], STUN_MAGIC_COOKIE)
+	copy(msg[8:], txId)
+	for _, attr := range attrs {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint16(header[0:], attr.attrType)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(attr.value)))
+		msg = append(msg, header...)
+		msg = append(msg, attr.value...)
+		msg = append(msg, make([]byte, (4-len(attr.value)%4)%4)...)
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)-STUN_HEADER_SIZE))
+	return msg
+}
+
+// stunAddress is the value of MAPPED-ADDRESS: [0][family][port][ip]
+func stunAddress(ip net.IP, port int) []byte {
+	var value []byte
+	if ip == nil {
+		ip = net.IPv6unspecified
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		value = append([]byte{0, 0x01, 0, 0}, ip4...)
+	} else {
+		value = append([]byte{0, 0x02, 0, 0}, ip.To16()...)
+	}
+	binary.BigEndian.PutUint16(value[2:], uint16(port))
+	return value
+}
+
+// stunXorAddress is the value of XOR-MAPPED-ADDRESS
+func stunXorAddress(addr *net.UDPAddr, txId []byte) []byte {
+	value := stunAddress(addr.IP, addr.Port)
+	key := make([]byte, 4, 16)
+	binary.BigEndian.PutUint32(key, STUN_MAGIC_COOKIE)
+	key = append(key, txId...)
+	value[2] ^= key[0]
+	value[3] ^= key[1]
+	for i := 4; i < len(value); i++ {
+		value[i] ^= key[i-4]
+	}
+	return value
+}
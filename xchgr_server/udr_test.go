@@ -0,0 +1,608 @@
+package xchgr_server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newUdrTestRouter starts a router with UDR on a loopback port, configure may change the config
+func newUdrTestRouter(t *testing.T, configure ...func(config *Config)) (*Router, *net.UDPConn) {
+	config := DefaultConfig()
+	config.UdpListener = "127.0.0.1:0"
+	config.DataDir = t.TempDir()
+	config.Premium.Provider = PREMIUM_PROVIDER_NONE
+	for _, f := range configure {
+		f(&config)
+	}
+	storage, _ := NewStorage(STORAGE_TYPE_MEMORY, "")
+	router := NewRouter(config, storage)
+	if err := router.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Stop() })
+
+	return router, dialUdr(t, router)
+}
+
+func dialUdr(t *testing.T, router *Router) *net.UDPConn {
+	router.udr.mtx.Lock()
+	routerAddr := router.udr.conn.LocalAddr().(*net.UDPAddr)
+	router.udr.mtx.Unlock()
+	conn, err := net.DialUDP("udp", nil, routerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// udrTestClient is an address with its key and socket
+type udrTestClient struct {
+	key     *rsa.PrivateKey
+	pub     []byte
+	address []byte
+	conn    *net.UDPConn
+}
+
+var udrTestTimestamp int64
+
+func newUdrTestClient(t *testing.T, router *Router) *udrTestClient {
+	var c udrTestClient
+	var err error
+	c.key, err = rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.pub = x509.MarshalPKCS1PublicKey(&c.key.PublicKey)
+	c.address = addressOfPublicKey(c.pub)
+	c.conn = dialUdr(t, router)
+	return &c
+}
+
+// timestamp is the current time, increasing for every packet
+func udrNextTimestamp() []byte {
+	ts := time.Now().UnixMilli() + atomic.AddInt64(&udrTestTimestamp, 1)
+	bs := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bs, uint64(ts))
+	return bs
+}
+
+// signed is [packetType][fields][uint16 pubLen][public key][signature]
+func (c *udrTestClient) signed(packetType byte, fields ...[]byte) []byte {
+	packet := []byte{packetType}
+	for _, field := range fields {
+		packet = append(packet, field...)
+	}
+	hash := sha256.Sum256(packet)
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, hash[:])
+	pubLen := make([]byte, 2)
+	binary.LittleEndian.PutUint16(pubLen, uint16(len(c.pub)))
+	packet = append(packet, pubLen...)
+	packet = append(packet, c.pub...)
+	return append(packet, signature...)
+}
+
+// exchange sends the packet and returns the reply, nil if there is none
+func (c *udrTestClient) exchange(packet []byte) []byte {
+	_, _ = c.conn.Write(packet)
+	return c.read()
+}
+
+func (c *udrTestClient) read() []byte {
+	buffer := make([]byte, UDR_READ_BUFFER_SIZE)
+	_ = c.conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	n, err := c.conn.Read(buffer)
+	if err != nil {
+		return nil
+	}
+	return buffer[:n]
+}
+
+func (c *udrTestClient) register(t *testing.T) {
+	ack := c.exchange(c.signed(UDR_PACKET_REGISTER, c.address, udrNextTimestamp()))
+	if len(ack) != UDR_ACK_SIZE || ack[0] != UDR_PACKET_REGISTER_ACK || ack[1] != UDR_STATUS_OK {
+		t.Fatalf("register: %x", ack)
+	}
+}
+
+func udrTestFrame(src byte, dest byte, payload []byte) []byte {
+	frame := append(make([]byte, FRAME_HEADER_SIZE), payload...)
+	copy(frame, premiumTestFrame(src, dest))
+	frame[0] = byte(len(frame))
+	return frame
+}
+
+// waitUdrMessages returns the frames stored for the address, the datagrams are processed asynchronously
+func waitUdrMessages(t *testing.T, router *Router, address string, count int) []byte {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var data []byte
+		n := 0
+		router.mtx.Lock()
+		addressStorage := router.addresses[address]
+		router.mtx.Unlock()
+		if addressStorage != nil {
+			addressStorage.mtx.Lock()
+			for _, m := range addressStorage.messages {
+				data = append(data, m.data...)
+				n++
+			}
+			addressStorage.mtx.Unlock()
+		}
+		if n >= count {
+			return data
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d frames for the address %s, expected %d", n, address, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUdrFramesAreCopied(t *testing.T) {
+	router, conn := newUdrTestRouter(t)
+
+	first := udrTestFrame(0xA0, 0x01, bytes.Repeat([]byte{0xAA}, 32))
+	second := udrTestFrame(0xA0, 0x02, bytes.Repeat([]byte{0x55}, 32))
+	_, _ = conn.Write(append([]byte{UDR_PACKET_FRAMES}, first...))
+	_ = waitUdrMessages(t, router, premiumTestAddress(0x01), 1)
+	_, _ = conn.Write(append([]byte{UDR_PACKET_FRAMES}, second...))
+	_ = waitUdrMessages(t, router, premiumTestAddress(0x02), 1)
+
+	data := waitUdrMessages(t, router, premiumTestAddress(0x01), 1)
+	if !bytes.Equal(data, first) {
+		t.Errorf("the stored frame is changed by the next datagram: %x", data)
+	}
+}
+
+func TestUdrRelayAllocationsPerAddress(t *testing.T) {
+	router, _ := newUdrTestRouter(t, func(config *Config) {
+		config.Udr.Relay.Enabled = true
+		config.Udr.Relay.MaxAllocationsPerAddress = 2
+	})
+	clients := make([]*udrTestClient, 4)
+	for i := range clients {
+		clients[i] = newUdrTestClient(t, router)
+		clients[i].register(t)
+	}
+
+	allocate := func(from *udrTestClient, to *udrTestClient) byte {
+		ack := from.exchange(from.signed(UDR_PACKET_RELAY_ALLOCATE, from.address, to.address, udrNextTimestamp()))
+		if len(ack) != UDR_RELAY_ACK_SIZE || ack[0] != UDR_PACKET_RELAY_ACK {
+			t.Fatalf("relay ack: %x", ack)
+		}
+		return ack[1]
+	}
+	a := clients[0]
+	if status := allocate(a, clients[1]); status != UDR_STATUS_PENDING {
+		t.Errorf("first allocation: %d", status)
+	}
+	if status := allocate(a, clients[2]); status != UDR_STATUS_PENDING {
+		t.Errorf("second allocation: %d", status)
+	}
+	if status := allocate(a, clients[3]); status != UDR_STATUS_TABLE_FULL {
+		t.Errorf("allocation over the limit of the address: %d", status)
+	}
+	// Repeated requests and the peers are not limited by the allocations of A
+	if status := allocate(a, clients[1]); status != UDR_STATUS_PENDING {
+		t.Errorf("repeated allocation: %d", status)
+	}
+	if status := allocate(clients[3], a); status != UDR_STATUS_PENDING {
+		t.Errorf("allocation of another address: %d", status)
+	}
+	if status := allocate(clients[1], a); status != UDR_STATUS_OK {
+		t.Errorf("allocation of the peer: %d", status)
+	}
+}
+
+func TestUdrStaleOnlyToEndpoint(t *testing.T) {
+	router, _ := newUdrTestRouter(t)
+	client := newUdrTestClient(t, router)
+	packet := client.signed(UDR_PACKET_REGISTER, client.address, udrNextTimestamp())
+	if ack := client.exchange(packet); len(ack) != UDR_ACK_SIZE || ack[1] != UDR_STATUS_OK {
+		t.Fatalf("register: %x", ack)
+	}
+
+	// The replay from another source is dropped
+	other := dialUdr(t, router)
+	_, _ = other.Write(packet)
+	_ = other.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if n, err := other.Read(make([]byte, 100)); err == nil {
+		t.Errorf("%d bytes sent to another source", n)
+	}
+	if ack := client.exchange(packet); len(ack) != UDR_ACK_SIZE || ack[1] != UDR_STATUS_STALE {
+		t.Errorf("replay from the endpoint: %x", ack)
+	}
+}
+
+func TestUdrRegister(t *testing.T) {
+	router, _ := newUdrTestRouter(t, func(config *Config) {
+		config.Udr.TtlMs = 200
+	})
+	client := newUdrTestClient(t, router)
+	address := addressKey(client.address)
+
+	// A wrong signature gets no reply and registers nothing
+	packet := client.signed(UDR_PACKET_REGISTER, client.address, udrNextTimestamp())
+	packet[len(packet)-1] ^= 0xFF
+	if ack := client.exchange(packet); ack != nil {
+		t.Errorf("reply to a wrong signature: %x", ack)
+	}
+	// A truncated packet too
+	if ack := client.exchange(packet[:UDR_REGISTER_HEADER-1]); ack != nil {
+		t.Errorf("reply to a truncated packet: %x", ack)
+	}
+	if _, ok := router.udr.NatInfo(address); ok {
+		t.Fatal("registered with a wrong signature")
+	}
+
+	ack := client.exchange(client.signed(UDR_PACKET_REGISTER, client.address, udrNextTimestamp()))
+	if len(ack) != UDR_ACK_SIZE || ack[0] != UDR_PACKET_REGISTER_ACK || ack[1] != UDR_STATUS_OK {
+		t.Fatalf("register: %x", ack)
+	}
+	if ttl := binary.LittleEndian.Uint32(ack[2+AddressBytesSize+8:]); ttl != 200 {
+		t.Errorf("ttl %d", ttl)
+	}
+	info, ok := router.udr.NatInfo(address)
+	if !ok || info.Endpoint != client.conn.LocalAddr().String() {
+		t.Errorf("record %+v, expected the endpoint %s", info, client.conn.LocalAddr())
+	}
+
+	// A timestamp out of the allowed clock skew
+	old := make([]byte, 8)
+	binary.LittleEndian.PutUint64(old, uint64(time.Now().Add(-time.Hour).UnixMilli()))
+	if ack := client.exchange(client.signed(UDR_PACKET_REGISTER, client.address, old)); len(ack) != UDR_ACK_SIZE || ack[1] != UDR_STATUS_STALE {
+		t.Errorf("old timestamp: %x", ack)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := router.udr.NatInfo(address); ok {
+		t.Error("the registration has not expired")
+	}
+}
+
+func TestUdrRendezvous(t *testing.T) {
+	router, _ := newUdrTestRouter(t, func(config *Config) {
+		config.Udr.ConnectIntervalMs = 0
+	})
+	a := newUdrTestClient(t, router)
+	b := newUdrTestClient(t, router)
+	a.register(t)
+
+	connect := func() []byte {
+		return a.exchange(a.signed(UDR_PACKET_CONNECT, a.address, b.address, udrNextTimestamp()))
+	}
+	if ack := connect(); len(ack) != UDR_CONNECT_ACK_SIZE || ack[0] != UDR_PACKET_CONNECT_ACK || ack[1] != UDR_STATUS_NO_PEER {
+		t.Errorf("connect to an unregistered peer: %x", ack)
+	}
+
+	b.register(t)
+	_, _ = a.conn.Write(a.signed(UDR_PACKET_CONNECT, a.address, b.address, udrNextTimestamp()))
+	// A gets the ack and the endpoint of B, B gets the endpoint of A
+	var ack, peerOfA []byte
+	for _, packet := range [][]byte{a.read(), a.read()} {
+		switch {
+		case len(packet) > 0 && packet[0] == UDR_PACKET_CONNECT_ACK:
+			ack = packet
+		case len(packet) > 0 && packet[0] == UDR_PACKET_PEER:
+			peerOfA = packet
+		}
+	}
+	if len(ack) != UDR_CONNECT_ACK_SIZE || ack[1] != UDR_STATUS_OK {
+		t.Fatalf("connect: %x", ack)
+	}
+	checkPeer := func(packet []byte, peer *udrTestClient) {
+		if len(packet) != UDR_PEER_SIZE || packet[0] != UDR_PACKET_PEER {
+			t.Fatalf("peer packet: %x", packet)
+		}
+		endpoint := net.UDPAddr{
+			IP:   net.IP(packet[1+AddressBytesSize : 1+AddressBytesSize+net.IPv6len]),
+			Port: int(binary.LittleEndian.Uint16(packet[1+AddressBytesSize+net.IPv6len:])),
+		}
+		if !bytes.Equal(packet[1:1+AddressBytesSize], peer.address) || endpoint.String() != peer.conn.LocalAddr().String() {
+			t.Errorf("peer %x at %s, expected %x at %s", packet[1:1+AddressBytesSize], endpoint.String(), peer.address, peer.conn.LocalAddr())
+		}
+	}
+	checkPeer(b.read(), a)
+	checkPeer(peerOfA, b)
+
+	// Too frequent requests
+	router.udr.mtx.Lock()
+	router.udr.config.ConnectIntervalMs = 60000
+	router.udr.mtx.Unlock()
+	if ack := connect(); len(ack) != UDR_CONNECT_ACK_SIZE || ack[1] != UDR_STATUS_RATE_LIMIT {
+		t.Errorf("too frequent connect: %x", ack)
+	}
+}
+
+// udrAddressFrame is a frame of the given size between two full addresses
+func udrAddressFrame(src []byte, dest []byte, size int) []byte {
+	frame := make([]byte, size)
+	binary.LittleEndian.PutUint32(frame[0:], uint32(size))
+	copy(frame[FRAME_SRC_ADDRESS_POS:], src)
+	copy(frame[FRAME_DEST_ADDRESS_POS:], dest)
+	return frame
+}
+
+func TestUdrSubscribePush(t *testing.T) {
+	router, conn := newUdrTestRouter(t)
+	client := newUdrTestClient(t, router)
+	afterId := make([]byte, 8)
+
+	subscribe := func() []byte {
+		return client.signed(UDR_PACKET_SUBSCRIBE, client.address, afterId, udrNextTimestamp())
+	}
+	if ack := client.exchange(subscribe()); len(ack) != UDR_SUBSCRIBE_ACK_SIZE || ack[0] != UDR_PACKET_SUBSCRIBE_ACK || ack[1] != UDR_STATUS_NOT_REG {
+		t.Fatalf("subscription of an unregistered address: %x", ack)
+	}
+	client.register(t)
+
+	var frames [][]byte
+	for i := 0; i < 3; i++ {
+		frames = append(frames, udrAddressFrame([]byte{0xA0}, client.address, FRAME_HEADER_SIZE+i+1))
+	}
+	_, _ = conn.Write(append([]byte{UDR_PACKET_FRAMES}, append(frames[0], frames[1]...)...))
+	_ = waitUdrMessages(t, router, addressKey(client.address), 2)
+
+	// The ack and the push of the backlog arrive in any order,
+	// afterId 0 skips the first frame (its id is 0)
+	_, _ = client.conn.Write(subscribe())
+	var ack, push []byte
+	for _, packet := range [][]byte{client.read(), client.read()} {
+		switch {
+		case len(packet) > 0 && packet[0] == UDR_PACKET_SUBSCRIBE_ACK:
+			ack = packet
+		case len(packet) > 0 && packet[0] == UDR_PACKET_PUSH:
+			push = packet
+		}
+	}
+	if len(ack) != UDR_SUBSCRIBE_ACK_SIZE || ack[1] != UDR_STATUS_OK || !bytes.Equal(ack[2:2+AddressBytesSize], client.address) {
+		t.Fatalf("subscribe: %x", ack)
+	}
+	if len(push) < UDR_PUSH_HEADER || push[1] != 0 || !bytes.Equal(push[UDR_PUSH_HEADER:], frames[1]) {
+		t.Fatalf("push of the backlog after the first frame: %x", push)
+	}
+	lastId := binary.LittleEndian.Uint64(push[2:])
+
+	// New frames are pushed as they are stored
+	_, _ = conn.Write(append([]byte{UDR_PACKET_FRAMES}, frames[2]...))
+	push = client.read()
+	if len(push) < UDR_PUSH_HEADER || push[0] != UDR_PACKET_PUSH || !bytes.Equal(push[UDR_PUSH_HEADER:], frames[2]) {
+		t.Fatalf("push of a new frame: %x", push)
+	}
+	if id := binary.LittleEndian.Uint64(push[2:]); id <= lastId {
+		t.Errorf("lastId %d after %d", id, lastId)
+	}
+
+	// A frame larger than a datagram is skipped and reported
+	large := udrAddressFrame([]byte{0xA0}, client.address, router.udr.config.MaxDatagramSize)
+	if err := router.PutFrames(large, nil); err != nil {
+		t.Fatal(err)
+	}
+	push = client.read()
+	if len(push) != UDR_PUSH_HEADER || push[0] != UDR_PACKET_PUSH || push[1]&UDR_PUSH_FLAG_SKIPPED == 0 {
+		t.Errorf("push of a skipped frame: %x", push)
+	}
+}
+
+func TestUdrFramesError(t *testing.T) {
+	router, conn := newUdrTestRouter(t)
+	client := newUdrTestClient(t, router)
+	client.register(t)
+
+	// The datagram is larger than max_datagram_size
+	frame := udrAddressFrame(client.address, []byte{0x01}, router.udr.config.MaxDatagramSize)
+	packet := append([]byte{UDR_PACKET_FRAMES}, frame...)
+	reply := client.exchange(packet)
+	if len(reply) < 2 || reply[0] != UDR_PACKET_ERROR {
+		t.Errorf("error reply to the endpoint of the sender: %x", reply)
+	}
+
+	// Another source of the same frames gets no reply
+	_, _ = conn.Write(packet)
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 100)); err == nil {
+		t.Errorf("%d bytes sent to another source", n)
+	}
+	if counters := router.udr.Counters(); counters.FramesRejected != 2 {
+		t.Errorf("%d rejected datagrams", counters.FramesRejected)
+	}
+}
+
+// stunTestSocket is an unconnected socket: the responses come from both ports of the router
+type stunTestSocket struct {
+	conn *net.UDPConn
+}
+
+func newStunTestSocket(t *testing.T) *stunTestSocket {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &stunTestSocket{conn: conn}
+}
+
+// exchange sends a binding request and returns the response with its source
+func (c *stunTestSocket) exchange(t *testing.T, to net.Addr, attrs ...stunAttr) ([]byte, *net.UDPAddr) {
+	txId := make([]byte, STUN_HEADER_SIZE-8)
+	_, _ = rand.Read(txId)
+	_, _ = c.conn.WriteTo(stunMessage(STUN_BINDING_REQUEST, txId, attrs), to)
+	response, from := c.read(t)
+	if len(response) < STUN_HEADER_SIZE || binary.BigEndian.Uint32(response[4:]) != STUN_MAGIC_COOKIE || !bytes.Equal(response[8:STUN_HEADER_SIZE], txId) {
+		t.Fatalf("not a STUN response: %x", response)
+	}
+	return response, from
+}
+
+func (c *stunTestSocket) read(t *testing.T) ([]byte, *net.UDPAddr) {
+	buffer := make([]byte, UDR_READ_BUFFER_SIZE)
+	_ = c.conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	n, from, err := c.conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatal("no response:", err)
+	}
+	return buffer[:n], from
+}
+
+func stunTestAttr(msg []byte, attrType uint16) []byte {
+	for offset := STUN_HEADER_SIZE; offset+4 <= len(msg); {
+		attrLen := int(binary.BigEndian.Uint16(msg[offset+2:]))
+		if binary.BigEndian.Uint16(msg[offset:]) == attrType {
+			return msg[offset+4 : offset+4+attrLen]
+		}
+		offset += 4 + (attrLen+3)/4*4
+	}
+	return nil
+}
+
+func TestUdrNatClassification(t *testing.T) {
+	router, _ := newUdrTestRouter(t, func(config *Config) {
+		config.Udr.AltListener = "127.0.0.1:0"
+	})
+	router.udr.mtx.Lock()
+	mainAddr := router.udr.conn.LocalAddr()
+	altAddr := router.udr.altConn.LocalAddr().(*net.UDPAddr)
+	router.udr.mtx.Unlock()
+
+	client := newUdrTestClient(t, router)
+	username := stunAttr{STUN_ATTR_USERNAME, []byte(addressKey(client.address))}
+	changePort := stunAttr{STUN_ATTR_CHANGE_REQ, []byte{0, 0, 0, byte(STUN_CHANGE_PORT_FLAG)}}
+
+	// classify runs the test with the first request from first and the rest from second
+	classify := func(first *stunTestSocket, second *stunTestSocket, withProbe bool) byte {
+		response, _ := first.exchange(t, mainAddr, username)
+		if mapped := stunTestAttr(response, STUN_ATTR_MAPPED); !bytes.Equal(mapped, stunAddress(net.IPv4(127, 0, 0, 1), first.conn.LocalAddr().(*net.UDPAddr).Port)) {
+			t.Errorf("MAPPED-ADDRESS %x", mapped)
+		}
+		nonce := stunAttr{STUN_ATTR_XCHG_NONCE, stunTestAttr(response, STUN_ATTR_XCHG_NONCE)}
+		if len(nonce.value) != STUN_NAT_NONCE_SIZE {
+			t.Fatalf("no nonce: %x", response)
+		}
+		attrs := []stunAttr{username, nonce}
+		if withProbe {
+			response, from := second.exchange(t, mainAddr, username, nonce, changePort)
+			if from.Port != altAddr.Port {
+				t.Errorf("CHANGE-REQUEST is answered from %s", from)
+			}
+			attrs = append(attrs, stunAttr{STUN_ATTR_XCHG_PROBE, stunTestAttr(response, STUN_ATTR_XCHG_PROBE)})
+		}
+		response, _ = second.exchange(t, altAddr, attrs...)
+		natType := stunTestAttr(response, STUN_ATTR_XCHG_NAT)
+		if len(natType) != 4 {
+			t.Fatalf("no NAT type: %x", response)
+		}
+		return natType[0]
+	}
+
+	// The record of the address gets the result of the tests from its endpoint
+	socket := newStunTestSocket(t)
+	_, _ = socket.conn.WriteTo(client.signed(UDR_PACKET_REGISTER, client.address, udrNextTimestamp()), mainAddr)
+	if ack, _ := socket.read(t); len(ack) != UDR_ACK_SIZE || ack[1] != UDR_STATUS_OK {
+		t.Fatalf("register: %x", ack)
+	}
+	if response, _ := socket.exchange(t, mainAddr); stunTestAttr(response, STUN_ATTR_XOR_MAPPED) == nil {
+		t.Fatalf("binding response: %x", response)
+	}
+	if info, ok := router.udr.NatInfo(addressKey(client.address)); !ok || info.NatType != NAT_TYPE_UNKNOWN {
+		t.Fatalf("record %+v", info)
+	}
+
+	if natType := classify(socket, socket, true); natType != natTypeCodes[NAT_TYPE_FULL_CONE] {
+		t.Errorf("full cone NAT is classified as %d", natType)
+	}
+	if info, _ := router.udr.NatInfo(addressKey(client.address)); info.NatType != NAT_TYPE_FULL_CONE {
+		t.Errorf("NAT type of the record: %s", info.NatType)
+	}
+	if natType := classify(socket, socket, false); natType != natTypeCodes[NAT_TYPE_RESTRICTED] {
+		t.Errorf("restricted NAT is classified as %d", natType)
+	}
+	if natType := classify(socket, newStunTestSocket(t), true); natType != natTypeCodes[NAT_TYPE_SYMMETRIC] {
+		t.Errorf("symmetric NAT is classified as %d", natType)
+	}
+	// The first request came from the endpoint of the record
+	if info, _ := router.udr.NatInfo(addressKey(client.address)); info.NatType != NAT_TYPE_SYMMETRIC {
+		t.Errorf("NAT type of the record: %s", info.NatType)
+	}
+	// Tests from other mappings do not change the record
+	other := newStunTestSocket(t)
+	if natType := classify(other, other, true); natType != natTypeCodes[NAT_TYPE_FULL_CONE] {
+		t.Errorf("full cone NAT is classified as %d", natType)
+	}
+	if info, _ := router.udr.NatInfo(addressKey(client.address)); info.NatType != NAT_TYPE_SYMMETRIC {
+		t.Errorf("NAT type of the record: %s", info.NatType)
+	}
+
+	// The router has a single IP address
+	changeIp := stunAttr{STUN_ATTR_CHANGE_REQ, []byte{0, 0, 0, byte(STUN_CHANGE_IP_FLAG)}}
+	response, _ := socket.exchange(t, mainAddr, changeIp)
+	if binary.BigEndian.Uint16(response) != STUN_BINDING_ERROR || !bytes.Equal(stunTestAttr(response, STUN_ATTR_ERROR_CODE)[2:4], []byte{4, 20}) {
+		t.Errorf("change IP: %x", response)
+	}
+}
+
+func TestUdrRelayData(t *testing.T) {
+	router, stranger := newUdrTestRouter(t, func(config *Config) {
+		config.Udr.Relay.Enabled = true
+		config.Udr.Relay.IdleTimeoutMs = 300
+	})
+	a := newUdrTestClient(t, router)
+	b := newUdrTestClient(t, router)
+	a.register(t)
+	b.register(t)
+
+	ack := a.exchange(a.signed(UDR_PACKET_RELAY_ALLOCATE, a.address, b.address, udrNextTimestamp()))
+	if len(ack) != UDR_RELAY_ACK_SIZE || ack[1] != UDR_STATUS_PENDING {
+		t.Fatalf("allocation of A: %x", ack)
+	}
+	channel := ack[2+AddressBytesSize+8 : 2+AddressBytesSize+8+8]
+	// Both sides get UDR_STATUS_OK when the peer allocates
+	for _, ack := range [][]byte{b.exchange(b.signed(UDR_PACKET_RELAY_ALLOCATE, b.address, a.address, udrNextTimestamp())), a.read()} {
+		if len(ack) != UDR_RELAY_ACK_SIZE || ack[1] != UDR_STATUS_OK || !bytes.Equal(ack[2+AddressBytesSize+8:2+AddressBytesSize+8+8], channel) {
+			t.Fatalf("active allocation: %x", ack)
+		}
+	}
+
+	data := func(channel []byte, payload string) []byte {
+		return append(append([]byte{UDR_PACKET_RELAY_DATA}, channel...), payload...)
+	}
+	_, _ = b.conn.Write(data(channel, "ping"))
+	if packet := a.read(); !bytes.Equal(packet, data(channel, "ping")) {
+		t.Errorf("B to A: %x", packet)
+	}
+	_, _ = a.conn.Write(data(channel, "ping"))
+	if packet := b.read(); !bytes.Equal(packet, data(channel, "ping")) {
+		t.Errorf("A to B: %x", packet)
+	}
+	if counters := router.udr.Counters(); counters.RelayDatagrams != 2 || counters.RelayBytes != 8 {
+		t.Errorf("counters %+v", counters)
+	}
+
+	// Datagrams of an unknown channel or from another endpoint are dropped
+	unknown := make([]byte, 8)
+	binary.LittleEndian.PutUint64(unknown, binary.LittleEndian.Uint64(channel)+1)
+	_, _ = a.conn.Write(data(unknown, "lost"))
+	_, _ = stranger.Write(data(channel, "lost"))
+	if packet := b.read(); packet != nil {
+		t.Errorf("dropped datagram is forwarded: %x", packet)
+	}
+
+	// The allocation is removed after idle_timeout_ms
+	time.Sleep(400 * time.Millisecond)
+	_, _ = a.conn.Write(data(channel, "late"))
+	if packet := b.read(); packet != nil {
+		t.Errorf("datagram of an idle allocation is forwarded: %x", packet)
+	}
+	if counters := router.udr.Counters(); counters.RelayDropped != 3 {
+		t.Errorf("%d datagrams dropped", counters.RelayDropped)
+	}
+}
@@ -0,0 +1,246 @@
+package xchgr_server
+
+import (
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ipoluianov/gomisc/logger"
+)
+
+//////////////////////////////////////////////////////
+// WebSocket protocol (/api/ws)
+// Every message is binary: [CMD][PAYLOAD]
+// Client -> Router:
+//   WS_CMD_READ  - payload is the same as the /api/r request.
+//                  Subscribes the connection to the address,
+//                  replaces the previous subscription.
+//   WS_CMD_WRITE - payload is the same as the /api/w request
+//   WS_CMD_WRITE_POW - [uint16 powLen][PoW][frames], see pow.go
+// Router -> Client:
+//   WS_CMD_READ  - payload is the same as the /api/r response.
+//                  Sent every time new frames are stored,
+//                  a backlog is sent in several messages.
+//   WS_CMD_ERROR - payload is the error text. After a read error
+//                  (e.g. an expired token) the subscription is dropped.
+//////////////////////////////////////////////////////
+
+const (
+	WS_CMD_READ  = byte(0x01)
+	WS_CMD_WRITE = byte(0x02)
+	WS_CMD_ERROR = byte(0x03)
+
+	WS_CMD_WRITE_POW = byte(0x04)
+
+	WS_PING_PERIOD   = 30 * time.Second
+	WS_PONG_WAIT     = 60 * time.Second
+	WS_WRITE_TIMEOUT = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  64 * 1024,
+	WriteBufferSize: 64 * 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+type WsSession struct {
+	mtx      sync.Mutex
+	mtxWrite sync.Mutex
+	router   *Router
+	conn     *websocket.Conn
+	ip       string
+
+	readRequest         []byte
+	subscriptionChanged chan struct{}
+	closed              chan struct{}
+}
+
+func NewWsSession(router *Router, conn *websocket.Conn, ip string) *WsSession {
+	var c WsSession
+	c.router = router
+	c.conn = conn
+	c.ip = ip
+	c.subscriptionChanged = make(chan struct{}, 1)
+	c.closed = make(chan struct{})
+	return &c
+}
+
+func (c *HttpServer) processWS(w http.ResponseWriter, r *http.Request) {
+	c.server.DeclareHttpRequestWS()
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Println("HttpServer processWS upgrade error:", err)
+		return
+	}
+
+	session := NewWsSession(c.server, conn, c.clientIP(r))
+	session.Run()
+}
+
+func (c *WsSession) Run() {
+	go c.thPush()
+
+	c.conn.SetReadLimit(c.router.Limits().MaxRequestSize + 1)
+	c.conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+	})
+
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		c.conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+		if messageType != websocket.BinaryMessage || len(data) < 1 {
+			continue
+		}
+		// Every message counts as a request of the client IP
+		err = c.router.AllowIp(c.ip)
+		if err != nil {
+			c.writeError(err.Error())
+			continue
+		}
+
+		switch data[0] {
+		case WS_CMD_READ:
+			if len(data) < 1+46 {
+				c.writeError("wrong frame size")
+				continue
+			}
+			c.mtx.Lock()
+			c.readRequest = make([]byte, len(data)-1)
+			copy(c.readRequest, data[1:])
+			c.mtx.Unlock()
+			select {
+			case c.subscriptionChanged <- struct{}{}:
+			default:
+			}
+		case WS_CMD_WRITE:
+			err = c.router.PutFrames(data[1:], nil)
+			if err != nil {
+				c.writeError(err.Error())
+			}
+		case WS_CMD_WRITE_POW:
+			if len(data) < 1+2 {
+				c.writeError("wrong frame size")
+				continue
+			}
+			powLen := int(binary.LittleEndian.Uint16(data[1:]))
+			if len(data) < 1+2+powLen {
+				c.writeError("wrong frame size")
+				continue
+			}
+			err = c.router.PutFrames(data[1+2+powLen:], data[1+2:1+2+powLen])
+			if err != nil {
+				c.writeError(err.Error())
+			}
+		default:
+			c.writeError("unknown command")
+		}
+	}
+
+	close(c.closed)
+	c.conn.Close()
+}
+
+func (c *WsSession) thPush() {
+	pingTicker := time.NewTicker(WS_PING_PERIOD)
+	defer pingTicker.Stop()
+
+	for {
+		c.mtx.Lock()
+		request := c.readRequest
+		c.mtx.Unlock()
+
+		if request == nil {
+			select {
+			case <-c.subscriptionChanged:
+				continue
+			case <-pingTicker.C:
+				if c.ping() != nil {
+					return
+				}
+				continue
+			case <-c.closed:
+				return
+			}
+		}
+
+		addressStorage, listener, err := c.router.Subscribe(request)
+		if err != nil {
+			c.writeError(err.Error())
+			c.dropReadRequest(request)
+			continue
+		}
+
+		resubscribe := false
+		for !resubscribe {
+			response, count, err := c.router.GetMessages(request)
+			if err != nil {
+				// The subscription is dropped, the client sends a new read request
+				c.writeError(err.Error())
+				c.dropReadRequest(request)
+				break
+			}
+			if len(response) >= 8 {
+				// Next read continues after the last sent frame
+				binary.LittleEndian.PutUint64(request[0:], binary.LittleEndian.Uint64(response[0:]))
+				if count > 0 {
+					err = c.write(append([]byte{WS_CMD_READ}, response...))
+					if err != nil {
+						c.router.Unsubscribe(addressStorage, listener)
+						return
+					}
+					// The backlog may be larger than maxSize
+					continue
+				}
+			}
+
+			select {
+			case <-listener:
+			case <-c.subscriptionChanged:
+				resubscribe = true
+			case <-pingTicker.C:
+				if c.ping() != nil {
+					c.router.Unsubscribe(addressStorage, listener)
+					return
+				}
+			case <-c.closed:
+				c.router.Unsubscribe(addressStorage, listener)
+				return
+			}
+		}
+		c.router.Unsubscribe(addressStorage, listener)
+	}
+}
+
+// dropReadRequest removes the subscription if it is still the failed request:
+// a read request received in the meantime is kept
+func (c *WsSession) dropReadRequest(request []byte) {
+	c.mtx.Lock()
+	if len(c.readRequest) > 0 && &c.readRequest[0] == &request[0] {
+		c.readRequest = nil
+	}
+	c.mtx.Unlock()
+}
+
+func (c *WsSession) write(data []byte) error {
+	c.mtxWrite.Lock()
+	defer c.mtxWrite.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	return c.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (c *WsSession) writeError(text string) {
+	_ = c.write(append([]byte{WS_CMD_ERROR}, []byte(text)...))
+}
+
+func (c *WsSession) ping() error {
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_WRITE_TIMEOUT))
+}
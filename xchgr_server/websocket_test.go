@@ -0,0 +1,19 @@
+package xchgr_server
+
+import "testing"
+
+func TestWsDropReadRequest(t *testing.T) {
+	session := NewWsSession(nil, nil, "")
+	failed := benchReadRequest(1)
+	session.readRequest = benchReadRequest(2)
+
+	// A read request received while the previous one was failing is kept
+	session.dropReadRequest(failed)
+	if session.readRequest == nil {
+		t.Fatal("the new read request is dropped")
+	}
+	session.dropReadRequest(session.readRequest)
+	if session.readRequest != nil {
+		t.Error("the failed read request is kept")
+	}
+}